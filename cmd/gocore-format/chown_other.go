@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// chown is a no-op on platforms without Unix file ownership.
+func chown(f *os.File, info os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// diffContext is the number of unchanged lines shown around each hunk.
+const diffContext = 3
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	a, b int  // positions in the old and new line slices
+	line string
+}
+
+// unifiedDiff returns a unified diff between a and b, labelled with name.
+// It returns nil when a and b are identical.
+func unifiedDiff(name string, a, b []byte) []byte {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	var out bytes.Buffer
+
+	fmt.Fprintf(&out, "--- %s.orig\n+++ %s\n", name, name)
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := max(i-diffContext, 0)
+
+		end := i
+		for j := i; j < len(ops) && j-end <= 2*diffContext; j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			}
+		}
+
+		end = min(end+diffContext, len(ops))
+
+		hunk := ops[start:end]
+
+		oldCount, newCount := 0, 0
+		for _, op := range hunk {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(hunk[0].a, oldCount), hunkRange(hunk[0].b, newCount))
+
+		for _, op := range hunk {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+
+			if len(op.line) == 0 || op.line[len(op.line)-1] != '\n' {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		i = end
+	}
+
+	return out.Bytes()
+}
+
+// ANSI escape sequences used by colorDiff.
+const (
+	colorReset = "\x1b[0m"
+	colorBold  = "\x1b[1m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorCyan  = "\x1b[36m"
+)
+
+// colorDiff returns diff, as made by unifiedDiff, with the file headers in
+// bold, hunk headers in cyan, deletions in red and additions in green.
+func colorDiff(diff []byte) []byte {
+	var out bytes.Buffer
+
+	for i, line := range splitLines(diff) {
+		color := ""
+
+		switch {
+		case i < 2:
+			color = colorBold
+		case line[0] == '@':
+			color = colorCyan
+		case line[0] == '-':
+			color = colorRed
+		case line[0] == '+':
+			color = colorGreen
+		}
+
+		if color == "" {
+			out.WriteString(line)
+			continue
+		}
+
+		out.WriteString(color)
+		out.WriteString(line[:len(line)-1])
+		out.WriteString(colorReset + "\n")
+	}
+
+	return out.Bytes()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines splits b into lines, keeping each line's terminating newline.
+func splitLines(b []byte) []string {
+	var lines []string
+
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			lines = append(lines, string(b))
+			break
+		}
+
+		lines = append(lines, string(b[:i+1]))
+		b = b[i+1:]
+	}
+
+	return lines
+}
+
+// diffLines computes an edit script turning x into y using a longest common
+// subsequence over the lines that differ between the common prefix and suffix.
+func diffLines(x, y []string) []diffOp {
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	mx, my := x[prefix:len(x)-suffix], y[prefix:len(y)-suffix]
+
+	lcs := make([][]int, len(mx)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(my)+1)
+	}
+
+	for i := len(mx) - 1; i >= 0; i-- {
+		for j := len(my) - 1; j >= 0; j-- {
+			if mx[i] == my[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(x)+len(y))
+
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{kind: ' ', a: i, b: i, line: x[i]})
+	}
+
+	i, j := 0, 0
+	for i < len(mx) || j < len(my) {
+		switch {
+		case i < len(mx) && j < len(my) && mx[i] == my[j]:
+			ops = append(ops, diffOp{kind: ' ', a: prefix + i, b: prefix + j, line: mx[i]})
+			i++
+			j++
+		case i < len(mx) && (j == len(my) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{kind: '-', a: prefix + i, b: prefix + j, line: mx[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', a: prefix + i, b: prefix + j, line: my[j]})
+			j++
+		}
+	}
+
+	for k := 0; k < suffix; k++ {
+		ops = append(ops, diffOp{kind: ' ', a: len(x) - suffix + k, b: len(y) - suffix + k, line: x[len(x)-suffix+k]})
+	}
+
+	return ops
+}
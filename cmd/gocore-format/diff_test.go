@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiffIdentical(t *testing.T) {
+	assert.Nil(t, unifiedDiff("a.conf", []byte("a = 1\n"), []byte("a = 1\n")))
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	old := "b=2\na = 1\n"
+	new := "a = 1\n\nb = 2\n\n"
+
+	assert.Equal(t, `--- a.conf.orig
++++ a.conf
+@@ -1,2 +1,4 @@
+-b=2
+ a = 1
++
++b = 2
++
+`, string(unifiedDiff("a.conf", []byte(old), []byte(new))))
+}
+
+func TestUnifiedDiffHunks(t *testing.T) {
+	old := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	new := "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n"
+
+	assert.Equal(t, `--- x.orig
++++ x
+@@ -1,3 +1,4 @@
++0
+ 1
+ 2
+ 3
+@@ -9,4 +10,3 @@
+ 9
+ 10
+ 11
+-12
+`, string(unifiedDiff("x", []byte(old), []byte(new))))
+}
+
+func TestUnifiedDiffNoTrailingNewline(t *testing.T) {
+	assert.Equal(t, `--- x.orig
++++ x
+@@ -1 +1 @@
+-a = 1
+\ No newline at end of file
++a = 1
+`, string(unifiedDiff("x", []byte("a = 1"), []byte("a = 1\n"))))
+}
+
+func TestColorDiff(t *testing.T) {
+	diff := unifiedDiff("a.conf", []byte("b=2\na = 1\n"), []byte("a = 1\nb = 2\n"))
+
+	assert.Equal(t, "\x1b[1m--- a.conf.orig\x1b[0m\n"+
+		"\x1b[1m+++ a.conf\x1b[0m\n"+
+		"\x1b[36m@@ -1,2 +1,2 @@\x1b[0m\n"+
+		"\x1b[31m-b=2\x1b[0m\n"+
+		" a = 1\n"+
+		"\x1b[32m+b = 2\x1b[0m\n", string(colorDiff(diff)))
+}
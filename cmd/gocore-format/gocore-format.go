@@ -1,267 +1,1121 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
-	"unicode"
+
+	"github.com/ordishs/gocore-format/format"
+)
+
+// stdinLabel names standard input in diagnostics unless -stdin-name is given.
+const stdinLabel = "<standard input>"
+
+type options struct {
+	write          bool
+	list           bool
+	diff           bool
+	noSort         bool
+	strict         bool
+	failOnWarnings bool
+	lintTypos      bool
+	output         string
+	verbose        bool
+	stdinName      string
+	foldCase       bool
+	validate       bool
+	merge          bool
+	mergeStrategy  format.MergeStrategy
+	include        bool
+	stripCommented bool
+	stripComments  bool
+	stat           bool
+	context        string
+	listKeys       bool
+	count          *counts
+	liveKeys       bool
+	maxLineLength  int
+	eolAuto        bool
+	backup         bool
+	backupSuffix   string
+	schema         *format.Schema
+	only           []string
+	canonicalBools bool
+	normalizeURLs  bool
+	watch          bool
+	trimEmpty      bool
+	color          bool
+	dedupe         bool
+	separator      byte
+	quiet          bool
+	edits          []keyEdit
+	template       string
+	values         map[string]string
+	allowMissing   bool
+	profiles       []string
+	outDir         string
+	json           bool
+	fromJSON       bool
+	toml           bool
+	sort           format.SortOptions
+	format         format.FormatOptions
+}
+
+// editOp is what a keyEdit does.
+type editOp int
+
+const (
+	opSet editOp = iota
+	opUnset
+	opComment
+	opUncomment
 )
 
-type Setting struct {
-	Key      string
-	Comments string
-	Variants []Variant
+// keyEdit is a -set, -unset, -comment or -uncomment flag, applied in the
+// order given.
+type keyEdit struct {
+	op      editOp
+	line    string         // the flag's value
+	variant format.Variant // the variant read from line for -set
+}
+
+// counts holds the totals that -count prints, summed over every input.
+type counts struct {
+	Settings int `json:"settings"`
+	Variants int `json:"variants"`
 }
 
-type Variant struct {
-	Commented bool
-	Key       string
-	Value     string
-	Comment   string // The comment after the key=value pair
+// write prints the totals as two numbers or, if asJSON is set, as a JSON
+// object.
+func (c *counts) write(w io.Writer, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(c)
+	}
+
+	_, err := fmt.Fprintf(w, "%d %d\n", c.Settings, c.Variants)
+
+	return err
 }
 
+// result records what happened when a single input was processed.
+type result struct {
+	changed  bool // the formatted output differs from the input
+	warned   bool // at least one diagnostic was reported
+	streamed bool // the file was formatted a setting at a time
+}
+
+// errUnformatted makes the program exit with status 1 without printing a
+// message, as when -l finds files that are not formatted.
+var errUnformatted = errors.New("files are not formatted")
+
+// errUsage reports invalid flags, which the flag package has already
+// described on stderr.
+var errUsage = errors.New("invalid usage")
+
 func main() {
+	os.Exit(execute(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// execute runs the command and returns its exit status. Errors are reported
+// on stderr so that stdout only ever carries formatted output.
+func execute(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	err := run(args, stdin, stdout, stderr)
+	if err != nil && !errors.Is(err, errUnformatted) && !errors.Is(err, errUsage) {
+		fmt.Fprintln(stderr, err)
+	}
+
+	return exitCode(err)
+}
+
+// exitCode returns the process exit status for an error returned by run.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	return 1
+}
+
+// run executes the command with the given arguments, excluding the program
+// name.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
 	var (
-		write    bool
-		help     bool
-		filename string
-		in       = os.Stdin
-		err      error
+		opts options
+		help bool
 	)
 
-	flag.BoolVar(&write, "w", false, "Write to file")
-	flag.BoolVar(&help, "h", false, "Help")
-	flag.Parse()
+	opts.sort.ContextOrder = format.DefaultContextOrder
 
-	if help {
-		flag.PrintDefaults()
-		return
-	}
+	flags := flag.NewFlagSet("gocore-format", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	flags.BoolVar(&opts.write, "w", false, "Write to file")
+	flags.StringVar(&opts.output, "o", "", "Write the output to `file` instead of stdout, leaving the input untouched")
+	flags.BoolVar(&opts.watch, "watch", false, "Format files in place, then again whenever they change, until interrupted")
+	flags.BoolVar(&opts.backup, "backup", false, "With -w, save the original of each file with -backup-suffix appended before rewriting it")
+	flags.StringVar(&opts.backupSuffix, "backup-suffix", ".bak", "Suffix for the backups made by -backup")
+	flags.StringVar(&opts.stdinName, "stdin-name", stdinLabel, "Name to use for standard input in diagnostics and -l/-d output")
+	flags.BoolVar(&opts.list, "l", false, "List files whose formatting differs and exit 1")
+	flags.BoolVar(&opts.diff, "d", false, "Display diffs instead of rewriting files")
+	color := "auto"
+	flags.Func("color", "Color -d output: auto (when stdout is a terminal and NO_COLOR is not set), always or never", func(s string) error {
+		if s != "auto" && s != "always" && s != "never" {
+			return fmt.Errorf("invalid color mode %q: must be auto, always or never", s)
+		}
 
-	args := flag.Args()
+		color = s
 
-	if len(args) > 0 {
-		filename = args[0]
+		return nil
+	})
+	flags.BoolVar(&opts.noSort, "n", false, "Keep settings in input order instead of sorting (shorthand for -no-sort)")
+	flags.BoolVar(&opts.noSort, "no-sort", false, "Keep settings in input order instead of sorting; replaces the default sort, and lets files be formatted without reading them whole")
+	flags.Var(&opts.sort.Strategy, "sort", "Sort `strategy`: upperfirst (uppercase keys first, the default), alpha (case-insensitive) or length (shortest keys first)")
+	flags.BoolVar(&opts.sort.BySection, "group-by-section", false, "Keep settings under the \"# [Section]\" marker they follow, sorting within each section")
+	flags.BoolVar(&opts.sort.LiveFirst, "live-first", false, "Within each setting, write live variants before commented-out ones")
+	flags.BoolVar(&opts.sort.SortVariants, "sort-variants", false, "Order variants within a setting: base key, then contexts by -context-order, then the rest alphabetically")
+	flags.Func("context-order", "Comma-separated context precedence for -sort-variants (default \""+strings.Join(format.DefaultContextOrder, ",")+"\")", func(s string) error {
+		opts.sort.ContextOrder = nil
+		for _, context := range strings.Split(s, ",") {
+			opts.sort.ContextOrder = append(opts.sort.ContextOrder, strings.TrimSpace(context))
+		}
 
-		in, err = os.Open(filename)
+		return nil
+	})
+	flags.BoolVar(&opts.strict, "strict", false, "Report all warnings, including malformed lines, and exit 1 if there are any (-v with -fail-on-warnings)")
+	flags.BoolVar(&opts.failOnWarnings, "fail-on-warnings", false, "Exit 1 if any warning is reported, after formatting every input as usual")
+	flags.BoolVar(&opts.quiet, "q", false, "Quiet: print no warnings or summaries, only errors; exit statuses are unchanged")
+	flags.BoolVar(&opts.verbose, "v", false, "Verbose: also warn about lines that are neither settings nor comments, and summarize each input")
+	flags.IntVar(&opts.maxLineLength, "max-line-length", 0, "Report formatted lines longer than this many characters")
+	flags.Func("schema", "Check keys and values against the JSON schema in `file`", func(path string) error {
+		f, err := os.Open(path)
 		if err != nil {
-			fmt.Println("Error opening file:", err)
-			return
+			return err
 		}
-		defer in.Close()
-	}
+		defer f.Close()
 
-	settings, err := readSettings(in)
-	if err != nil {
-		fmt.Println("Error reading file:", err)
-		return
-	}
+		opts.schema, err = format.DecodeSchema(f)
 
-	sortSettings(settings)
+		return err
+	})
+	flags.BoolVar(&opts.lintTypos, "lint-typos", false, "Report root keys that differ from another by a single character, ignoring case")
+	flags.BoolVar(&opts.validate, "validate", false, "Report keys that break the gocore naming rules")
+	flags.BoolVar(&opts.foldCase, "case-insensitive-group", false, "Group root keys that differ only by case, warning about each merge")
+	flags.BoolVar(&opts.merge, "merge", false, "Merge all files into one config on stdout; later files override earlier ones")
+	flags.Var(&opts.mergeStrategy, "merge-strategy", "With -merge, what to do when files set a key to different values: last (the default), first, error or keep-both (the earlier one commented out)")
+	flags.BoolVar(&opts.dedupe, "dedupe", false, "Leave out comment lines before a repeated root key that its earlier comments already hold")
+	flags.BoolVar(&opts.include, "include", false, "Inline files named by #include or @include directives, relative to the including file")
+	flags.Func("only", "Format only the settings whose root key starts with `prefix`, keeping all other lines as they are; may be repeated", func(prefix string) error {
+		opts.only = append(opts.only, prefix)
+		return nil
+	})
+	flags.BoolVar(&opts.canonicalBools, "canonical-bools", false, "Write boolean values such as yes and off as true or false; with -schema, only for keys of type bool")
+	flags.BoolVar(&opts.normalizeURLs, "normalize-urls", false, "Write URL values, and URLs in '|' lists, with the scheme and host in lower case, no default http or https port and no trailing slash")
+	flags.BoolVar(&opts.stripCommented, "strip-commented", false, "Drop commented-out variants and any setting left without variants")
+	flags.BoolVar(&opts.trimEmpty, "trim-empty-settings", false, "Drop comment blocks that belong to no setting, such as one at the end of the file")
+	flags.BoolVar(&opts.stripComments, "strip-comments", false, "Drop comment blocks and inline comments, keeping commented-out variants")
+	flags.StringVar(&opts.context, "context", "", "Print only the effective value of each key in this `context`, falling back through its parent contexts to the base key and leaving out keys with neither")
+	flags.Func("profile", "Write the effective settings of this `context` to a file named after each input, such as settings.dev.conf; may be repeated", func(profile string) error {
+		opts.profiles = append(opts.profiles, profile)
+		return nil
+	})
+	flags.StringVar(&opts.outDir, "out-dir", "", "Directory for the files written by -profile (default: next to each input)")
+	flags.BoolVar(&opts.listKeys, "list-keys", false, "Print every full key, one per line and sorted; commented-out keys start with '#'")
+	count := false
+	flags.BoolVar(&count, "count", false, "Print only the number of settings and of variants, summed over every input; with -json, as a JSON object")
+	flags.BoolVar(&opts.liveKeys, "keys-only-live", false, "With -list-keys, leave out commented-out keys")
+	flags.BoolVar(&opts.stat, "stat", false, "Print a summary of what formatting would change in each file without changing anything")
+	flags.BoolVar(&opts.json, "json", false, "Print the parsed settings as JSON instead of formatting them")
+	flags.BoolVar(&opts.toml, "toml", false, "Print the settings as TOML instead of formatting them")
+	flags.BoolVar(&opts.fromJSON, "from-json", false, "Read input in the -json representation and print it as a formatted conf")
+	flags.IntVar(&opts.format.WrapWidth, "wrap", 0, "Wrap multi-value lists longer than this many columns onto continuation lines")
+	flags.BoolVar(&opts.format.AlignComments, "align-comments", false, "Align inline comments within each setting to a common column")
+	flags.BoolVar(&opts.format.PreserveBlanks, "preserve-blanks", false, "Keep a blank line between variants of a setting that were separated in the input")
+	flags.BoolVar(&opts.format.Indent, "indent", false, "Indent variant keys by their dot depth below the root key")
+	flags.Func("set", "Set a variant from a `key=value` line, adding it or its setting if missing; may be repeated, applying in order", func(line string) error {
+		opts.edits = append(opts.edits, keyEdit{line: line})
+		return nil
+	})
+	flags.Func("unset", "Remove every variant, live or commented out, with this full `key`, and any setting left empty; may be repeated", func(key string) error {
+		opts.edits = append(opts.edits, keyEdit{op: opUnset, line: strings.TrimSpace(key)})
+		return nil
+	})
+	flags.Func("comment", "Comment out every variant with this full `key`; may be repeated", func(key string) error {
+		opts.edits = append(opts.edits, keyEdit{op: opComment, line: strings.TrimSpace(key)})
+		return nil
+	})
+	flags.Func("uncomment", "Uncomment the last commented-out variant with this full `key`, unless one is live; may be repeated", func(key string) error {
+		opts.edits = append(opts.edits, keyEdit{op: opUncomment, line: strings.TrimSpace(key)})
+		return nil
+	})
+	flags.StringVar(&opts.template, "template", "", "Format `file`, replacing {{name}} placeholders in its values with those from the -values file")
+	flags.Func("values", "With -template, read placeholder values from the key=value pairs in `file`", func(path string) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
 
-	if filename != "" && write {
-		in.Close()
+		opts.values, err = format.ReadValues(f)
 
-		out, err := os.Create(filename + ".tmp")
-		if err != nil {
-			fmt.Println("Error creating output file:", err)
-			return
+		return err
+	})
+	flags.BoolVar(&opts.allowMissing, "allow-missing", false, "With -template, leave placeholders without a value as written")
+	flags.BoolVar(&opts.format.ExpandEnv, "expand-env", false, "Expand ${VAR} and $VAR references in values from the environment")
+	flags.BoolVar(&opts.format.KeepUnsetEnv, "keep-unset-env", false, "With -expand-env, leave references to unset variables as written")
+	flags.IntVar(&opts.format.TabWidth, "tab-width", 1, "Write each tab in a value or comment as this many spaces")
+	flags.BoolVar(&opts.format.NoAlign, "no-align", false, "Write \"key = value\" without padding keys to a common width")
+	flags.Var(widthValue{&opts.format}, "width", "Align '=' across the whole file: alone, to the longest key; as -width=N, to N columns")
+	flags.Func("comment-char", "Character that starts comments and commented-out variants (default \"#\")", func(s string) error {
+		if len(s) != 1 || !strings.ContainsAny(s, "#;!%/") {
+			return fmt.Errorf("invalid comment character %q: must be one of # ; ! %% /", s)
 		}
-		defer out.Close()
 
-		if err := writeSettings(out, settings); err != nil {
-			fmt.Println("Error writing file:", err)
-			return
+		opts.format.CommentChar = s[0]
+
+		return nil
+	})
+	flags.Func("separator", "Text written between each key and its value (default \" = \"); input is split on its one non-space character", func(s string) error {
+		c := strings.TrimSpace(s)
+		if len(c) != 1 {
+			return fmt.Errorf("invalid separator %q: must hold exactly one character other than spaces", s)
 		}
 
-		if err := os.Rename(filename+".tmp", filename); err != nil {
-			fmt.Println("Error renaming file:", err)
-			return
+		opts.format.Separator, opts.separator = s, c[0]
+
+		return nil
+	})
+	flags.BoolVar(&opts.format.Stamp, "stamp", false, "Start the output with a \"# "+format.Stamp+"\" comment, replacing any earlier stamp")
+	flags.BoolVar(&opts.format.RawValues, "raw-values", false, "Keep values wrapped in backticks exactly as written, comment characters and all")
+	flags.BoolVar(&opts.format.SortValues, "sort-values", false, "Sort the values of '|' separated lists and drop repeats")
+	flags.Func("eol", "Line endings to write: lf (the default), crlf, or auto to match the input", func(s string) error {
+		switch s {
+		case "lf":
+			opts.format.CRLF, opts.eolAuto = false, false
+		case "crlf":
+			opts.format.CRLF, opts.eolAuto = true, false
+		case "auto":
+			opts.format.CRLF, opts.eolAuto = false, true
+		default:
+			return fmt.Errorf("invalid line ending %q: must be lf, crlf or auto", s)
 		}
-	} else {
-		if err := writeSettings(os.Stdout, settings); err != nil {
-			fmt.Println("Error writing file:", err)
-			return
+
+		return nil
+	})
+	flags.BoolVar(&opts.format.NoPipeNormalize, "no-pipe-normalize", false, "Leave the spacing around '|' in values untouched")
+	flags.BoolVar(&help, "h", false, "Help")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
 		}
+
+		return errUsage
 	}
-}
 
-func readSettings(r io.Reader) ([]*Setting, error) {
-	var pendingSectionComment string
+	if help {
+		flags.PrintDefaults()
+		return nil
+	}
 
-	settings := make(map[string]*Setting)
+	inputs := flags.Args()
 
-	scanner := bufio.NewScanner(r)
+	if opts.template != "" {
+		if opts.values == nil {
+			return errors.New("-template needs a -values file")
+		}
 
-	for scanner.Scan() {
-		line := scanner.Text()
+		if opts.write || opts.watch {
+			return errors.New("cannot use -w or -watch with -template, it would fill in the template itself")
+		}
 
-		line = strings.TrimSpace(line)
+		inputs = append([]string{opts.template}, inputs...)
+	}
+
+	if c := opts.format.CommentChar; opts.separator != 0 && (opts.separator == c || c == 0 && opts.separator == format.DefaultCommentChar) {
+		return fmt.Errorf("cannot use the comment character as the separator")
+	}
 
-		if line == "" {
+	// -set lines are read once every flag is known, as -comment-char and
+	// -separator change how.
+	for i := range opts.edits {
+		e := &opts.edits[i]
+		if e.op != opSet {
 			continue
 		}
 
-		item := processLine(line)
+		variant, err := format.ParseVariant(e.line, format.ParseOptions{
+			CommentChar: opts.format.CommentChar,
+			Separator:   opts.separator,
+			RawValues:   opts.format.RawValues,
+		})
+		if err != nil {
+			return fmt.Errorf("invalid -set: %w", err)
+		}
 
-		if item == nil {
-			// This is an arbitrary comment line
-			line = strings.TrimSpace(line[1:])
+		e.variant = variant
+	}
 
-			if pendingSectionComment == "" {
-				pendingSectionComment = line
-			} else {
-				pendingSectionComment += "\n" + line
-			}
-		} else {
-			rootKey := strings.Split(item.Key, ".")[0]
+	if count {
+		if opts.write || opts.list || opts.diff || opts.watch || opts.stat || opts.listKeys || opts.toml || len(opts.profiles) > 0 {
+			return errors.New("cannot use -count with -w, -l, -d, -watch, -stat, -list-keys, -toml or -profile")
+		}
 
-			setting, found := settings[rootKey]
-			if !found {
-				setting = &Setting{
-					Key:      rootKey,
-					Comments: pendingSectionComment,
-				}
+		opts.count = &counts{}
+	}
 
-				pendingSectionComment = ""
-			}
+	var out *bytes.Buffer
 
-			setting.Variants = append(setting.Variants, *item)
+	if opts.output != "" {
+		if opts.write || opts.list || opts.diff || opts.watch || len(opts.profiles) > 0 {
+			return errors.New("cannot use -o with -w, -l, -d, -watch or -profile")
+		}
 
-			settings[rootKey] = setting
+		if len(inputs) > 1 && !opts.merge {
+			return errors.New("cannot use -o with more than one input unless merging")
 		}
+
+		// The output is written in one go once every input has been read, so
+		// that a failure leaves any earlier output file as it was. A streamed
+		// file is written to it through a temporary file instead.
+		out = &bytes.Buffer{}
+		stdout = out
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	switch color {
+	case "always":
+		opts.color = true
+	case "auto":
+		opts.color = isTerminal(stdout) && os.Getenv("NO_COLOR") == ""
 	}
 
-	settingsSlice := make([]*Setting, 0, len(settings))
-	for _, setting := range settings {
-		settingsSlice = append(settingsSlice, setting)
+	var (
+		unformatted bool
+		warned      bool
+	)
+
+	// Everything written to warnings, as opposed to the errors returned,
+	// is informational.
+	warnings := stderr
+	if opts.quiet {
+		warnings = io.Discard
 	}
 
-	return settingsSlice, nil
-}
+	switch {
+	case len(opts.profiles) > 0:
+		res, err := writeProfiles(inputs, stdout, warnings, opts)
+		if err != nil {
+			return err
+		}
+
+		warned = res.warned
 
-func writeSettings(w io.Writer, settings []*Setting) error {
-	writer := bufio.NewWriter(w)
-	defer writer.Flush()
+	case opts.watch:
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
 
-	for _, setting := range settings {
-		if setting.Comments != "" {
-			_, err := writer.WriteString("# " + setting.Comments + "\n")
+		return watch(ctx, inputs, poll, stdout, stderr, opts)
+
+	case opts.merge:
+		var filenames []string
+
+		for _, arg := range inputs {
+			files, err := expandDir(arg)
 			if err != nil {
 				return err
 			}
-		}
 
-		maxKeyLength := 0
+			filenames = append(filenames, files...)
+		}
 
-		for _, variant := range setting.Variants {
+		res, err := mergeFiles(filenames, stdout, warnings, opts)
+		if err != nil {
+			return err
+		}
 
-			l := len(variant.Key)
-			if variant.Commented {
-				l += 2
-			}
+		warned = res.warned
 
-			if l > maxKeyLength {
-				maxKeyLength = l
-			}
+	case len(inputs) == 0:
+		res, err := processFile("", stdin, stdout, warnings, opts)
+		if err != nil {
+			return err
 		}
 
-		for _, variant := range setting.Variants {
-			prefix := ""
+		unformatted, warned = res.changed, res.warned
 
-			length := maxKeyLength
+	default:
+		for _, filename := range inputs {
+			if info, err := os.Stat(filename); err == nil && info.IsDir() {
+				if opts.write || opts.list || opts.diff {
+					return fmt.Errorf("cannot use -w, -l or -d with directory %s, its files are merged into one output", filename)
+				}
 
-			if variant.Commented {
-				prefix = "# "
-				length -= 2
-			}
+				files, err := expandDir(filename)
+				if err != nil {
+					return err
+				}
 
-			value := cleanMultiValues(variant.Value)
+				res, err := mergeFiles(files, stdout, warnings, opts)
+				if err != nil {
+					return err
+				}
 
-			line := fmt.Sprintf("%s%-*s = %s", prefix, length, variant.Key, value)
+				warned = warned || res.warned
 
-			if variant.Comment != "" {
-				line += " # " + variant.Comment
+				continue
 			}
 
-			_, err := writer.WriteString(line + "\n")
+			res, err := formatFile(filename, stdout, warnings, opts)
 			if err != nil {
 				return err
 			}
+
+			if res.streamed {
+				// With -o the output file has been written already.
+				out = nil
+			}
+
+			unformatted = unformatted || res.changed
+			warned = warned || res.warned
 		}
+	}
 
-		_, err := writer.WriteString("\n")
-		if err != nil {
+	if opts.count != nil {
+		if err := opts.count.write(stdout, opts.json); err != nil {
+			return fmt.Errorf("error writing counts: %w", err)
+		}
+	}
+
+	if out != nil {
+		if err := writeFile(opts.output, func(w io.Writer) error {
+			_, err := w.Write(out.Bytes())
+			return err
+		}); err != nil {
 			return err
 		}
 	}
 
+	if (opts.list || opts.diff) && unformatted {
+		return errUnformatted
+	}
+
+	if opts.strict && warned {
+		return fmt.Errorf("warnings reported with -strict")
+	}
+
+	if opts.failOnWarnings && warned {
+		return fmt.Errorf("warnings reported with -fail-on-warnings")
+	}
+
 	return nil
 }
 
-func processLine(line string) *Variant {
+// expandDir returns the .conf files in arg in lexical order if arg is a
+// directory, or arg itself otherwise.
+func expandDir(arg string) ([]string, error) {
+	info, err := os.Stat(arg)
+	if err != nil || !info.IsDir() {
+		return []string{arg}, nil
+	}
+
+	entries, err := os.ReadDir(arg)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory: %w", err)
+	}
 
-	setting := &Variant{}
+	var files []string
 
-	if strings.HasPrefix(line, "#") {
-		setting.Commented = true
-		line = line[1:]
+	for _, entry := range entries {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".conf" {
+			files = append(files, filepath.Join(arg, entry.Name()))
+		}
 	}
 
-	parts := strings.SplitN(line, "=", 2)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .conf files in %s", arg)
+	}
 
-	if len(parts) == 1 {
-		return nil
+	return files, nil
+}
+
+// formatFile formats filename with streamFile if opts allow it and the file
+// can be streamed, and otherwise opens it and passes it to processFile.
+func formatFile(filename string, stdout, stderr io.Writer, opts options) (result, error) {
+	if canStream(opts) {
+		res, streamed, err := streamFile(filename, stdout, stderr, opts)
+		if err != nil || streamed {
+			res.streamed = streamed
+			return res, err
+		}
+	}
+
+	in, err := os.Open(filename)
+	if err != nil {
+		return result{}, fmt.Errorf("error opening file: %w", err)
 	}
+	defer in.Close()
+
+	return processFile(filename, in, stdout, stderr, opts)
+}
 
-	setting.Key = cleanKey(parts[0])
+// processFile formats the contents of in and reports whether the formatted
+// output differs from the original bytes. An empty filename means in is
+// standard input.
+func processFile(filename string, in io.Reader, stdout, stderr io.Writer, opts options) (result, error) {
+	var res result
 
-	line = strings.TrimSpace(parts[1])
+	if filename == "" && opts.write {
+		return res, errors.New("cannot use -w when reading from stdin")
+	}
+
+	if opts.stat && opts.write {
+		return res, errors.New("cannot use -stat with -w")
+	}
 
-	valueParts := strings.SplitN(line, "#", 2)
-	setting.Value = strings.TrimSpace(valueParts[0])
+	src, err := io.ReadAll(in)
+	if err != nil {
+		return res, fmt.Errorf("error reading file: %w", err)
+	}
 
-	if len(valueParts) > 1 {
-		setting.Comment = strings.TrimSpace(valueParts[1])
+	name := filename
+	if name == "" {
+		name = opts.stdinName
 	}
 
-	return setting
+	settings, warned, err := readSettings(name, src, stderr, opts)
+	if err != nil {
+		return res, err
+	}
+
+	res.warned = warned
+
+	if len(opts.only) > 0 {
+		var warned bool
+
+		res.changed, warned, err = emitOnly(filename, name, src, stdout, stderr, opts)
+		res.warned = res.warned || warned
+
+		return res, err
+	}
+
+	settings = transform(settings, opts)
+
+	res.warned = reportLongLines(stderr, name, settings, opts) || res.warned
+	res.changed, err = emit(filename, name, src, settings, stdout, stderr, opts)
+
+	return res, err
 }
 
-func cleanKey(key string) string {
-	parts := strings.Split(strings.TrimSpace(key), ".")
+// emitOnly formats the settings of src whose root key starts with one of the
+// -only prefixes in place of the first of them, keeping every other line as
+// it is. It also reports whether any of them has a long line.
+func emitOnly(filename, name string, src []byte, stdout, stderr io.Writer, opts options) (bool, bool, error) {
+	if opts.fromJSON || opts.include || opts.json || opts.toml || opts.stat || opts.listKeys || opts.template != "" || opts.format.Stamp || len(opts.edits) > 0 || opts.count != nil {
+		return false, false, errors.New("cannot use -only with -from-json, -include, -json, -toml, -stat, -list-keys, -template, -stamp, -set, -unset, -comment, -uncomment or -count")
+	}
 
-	for i := 0; i < len(parts); i++ {
-		parts[i] = strings.TrimSpace(parts[i])
+	parseOpts := format.ParseOptions{
+		CaseInsensitiveGroup: opts.foldCase,
+		CommentChar:          opts.format.CommentChar,
+		Separator:            opts.separator,
+		RawValues:            opts.format.RawValues,
+		DedupeComments:       opts.dedupe,
 	}
 
-	return strings.Join(parts, ".")
+	selected, before, after, err := format.Select(src, parseOpts, func(key string) bool {
+		for _, prefix := range opts.only {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+
+		return false
+	})
+	if err != nil {
+		return false, false, fmt.Errorf("error reading file: %w", err)
+	}
+
+	selected = transform(selected, opts)
+	warned := reportLongLines(stderr, name, selected, opts)
+
+	if !opts.noSort {
+		format.SortWithOptions(selected, opts.sort)
+	}
+
+	if opts.eolAuto {
+		opts.format.CRLF = format.DetectCRLF(src)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(before)
+
+	if err := format.FormatWithOptions(&buf, selected, opts.format); err != nil {
+		return false, warned, fmt.Errorf("error formatting file: %w", err)
+	}
+
+	buf.Write(after)
+
+	changed, err := output(filename, name, src, buf.Bytes(), stdout, opts)
+
+	return changed, warned, err
 }
 
-func cleanMultiValues(value string) string {
-	parts := strings.Split(value, "|")
-	for i, part := range parts {
-		parts[i] = strings.TrimSpace(part)
+// mergeFiles merges the settings of every file, later files overriding
+// earlier ones, and prints the result.
+func mergeFiles(filenames []string, stdout, stderr io.Writer, opts options) (result, error) {
+	var res result
+
+	if opts.write || opts.list || opts.diff {
+		return res, errors.New("cannot use -merge with -w, -l or -d")
 	}
 
-	return strings.Join(parts, " | ")
+	if opts.stat {
+		return res, errors.New("cannot use -merge with -stat")
+	}
+
+	if len(opts.only) > 0 {
+		return res, errors.New("cannot use -merge with -only")
+	}
+
+	sources := make([]format.Source, 0, len(filenames))
+
+	for _, filename := range filenames {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			return res, fmt.Errorf("error reading file: %w", err)
+		}
+
+		settings, warned, err := readSettings(filename, src, stderr, opts)
+		if err != nil {
+			return res, fmt.Errorf("%s: %w", filename, err)
+		}
+
+		res.warned = res.warned || warned
+
+		sources = append(sources, format.Source{Name: filename, Settings: settings})
+	}
+
+	settings, overrides, err := format.MergeWithOptions(sources, format.MergeOptions{Strategy: opts.mergeStrategy})
+	if err != nil {
+		return res, fmt.Errorf("merge conflict: %w", err)
+	}
+
+	for _, o := range overrides {
+		if opts.mergeStrategy == format.MergeFirst {
+			fmt.Fprintf(stderr, "%s:%d: %q is ignored, keeping the value from %s:%d\n", o.NewSource, o.New.Line, o.Key, o.OldSource, o.Old.Line)
+		} else {
+			fmt.Fprintf(stderr, "%s:%d: %q overrides the value from %s:%d\n", o.NewSource, o.New.Line, o.Key, o.OldSource, o.Old.Line)
+		}
+	}
+
+	res.warned = res.warned || len(overrides) > 0
+
+	settings = transform(settings, opts)
+
+	if opts.maxLineLength > 0 {
+		diagnostics := format.LongLines(settings, opts.format, opts.maxLineLength)
+
+		// The merged settings come from several inputs, so the line a
+		// variant was read from does not say which.
+		for i := range diagnostics {
+			diagnostics[i].Line = 0
+		}
+
+		reportDiagnostics(stderr, "<merged output>", diagnostics)
+
+		res.warned = res.warned || len(diagnostics) > 0
+	}
+
+	_, err = emit("", "", nil, settings, stdout, stderr, opts)
+
+	return res, err
+}
+
+// readSettings parses src and reports any diagnostics for it on stderr. It
+// also reports whether there were any.
+func readSettings(name string, src []byte, stderr io.Writer, opts options) ([]*format.Setting, bool, error) {
+	var (
+		settings    []*format.Setting
+		diagnostics []format.Diagnostic
+		err         error
+	)
+
+	if opts.fromJSON {
+		if opts.write {
+			return nil, false, fmt.Errorf("cannot use -w with -from-json")
+		}
+
+		settings, err = format.DecodeJSON(bytes.NewReader(src))
+		if err == nil {
+			diagnostics = format.Lint(settings)
+		}
+	} else {
+		if opts.include && opts.write {
+			return nil, false, errors.New("cannot use -w with -include, it would inline the included files")
+		}
+
+		parseOpts := format.ParseOptions{
+			CaseInsensitiveGroup: opts.foldCase,
+			Includes:             opts.include,
+			CommentChar:          opts.format.CommentChar,
+			Separator:            opts.separator,
+			RawValues:            opts.format.RawValues,
+			Sections:             opts.sort.BySection,
+			DedupeComments:       opts.dedupe,
+		}
+
+		if name != stdinLabel {
+			parseOpts.Filename = name
+		}
+
+		settings, diagnostics, err = format.ParseWithDiagnostics(bytes.NewReader(src), parseOpts)
+	}
+
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading file: %w", err)
+	}
+
+	if opts.format.Stamp {
+		settings = format.StripStamp(settings)
+	}
+
+	if opts.template != "" {
+		if err := format.Template(settings, opts.values, opts.allowMissing); err != nil {
+			return nil, false, fmt.Errorf("error filling template: %w", err)
+		}
+	}
+
+	settings, unmatched := edit(settings, opts)
+
+	diagnostics = append(diagnostics, unmatched...)
+	diagnostics = shown(lint(diagnostics, settings, opts), opts)
+
+	reportDiagnostics(stderr, name, diagnostics)
+
+	return settings, len(diagnostics) > 0, nil
+}
+
+// edit applies the -set, -unset, -comment and -uncomment edits selected by
+// opts to settings, in order, and reports each of the others that matched no
+// variant.
+func edit(settings []*format.Setting, opts options) ([]*format.Setting, []format.Diagnostic) {
+	edited := format.Settings(settings)
+
+	var diagnostics []format.Diagnostic
+
+	for _, e := range opts.edits {
+		var (
+			found bool
+			flag  string
+		)
+
+		switch e.op {
+		case opSet:
+			edited.Upsert(e.variant.Key, "", e.variant.Value, e.variant.Comment)
+			found = true
+		case opUnset:
+			found, flag = edited.Delete(e.line, ""), "-unset"
+		case opComment:
+			found, flag = edited.SetCommented(e.line, "", true), "-comment"
+		case opUncomment:
+			found, flag = edited.SetCommented(e.line, "", false), "-uncomment"
+		}
+
+		if !found {
+			diagnostics = append(diagnostics, format.Diagnostic{Message: fmt.Sprintf("%s %q matched no variant", flag, e.line)})
+		}
+	}
+
+	return edited, diagnostics
+}
+
+// lint adds the diagnostics of the checks that opts turns on for settings to
+// diagnostics, which hold those of format.Lint.
+func lint(diagnostics []format.Diagnostic, settings []*format.Setting, opts options) []format.Diagnostic {
+	if opts.foldCase {
+		diagnostics = append(diagnostics, format.MergedCasings(settings)...)
+	}
+
+	if opts.validate {
+		// InvalidKeys reports the keys with an empty segment as well.
+		empty := format.EmptySegments(settings)
+		diagnostics = slices.DeleteFunc(diagnostics, func(d format.Diagnostic) bool {
+			return slices.Contains(empty, d)
+		})
+		diagnostics = append(diagnostics, format.InvalidKeys(settings)...)
+	}
+
+	if opts.lintTypos {
+		diagnostics = append(diagnostics, format.Typos(settings, 1)...)
+	}
+
+	if opts.schema != nil {
+		diagnostics = append(diagnostics, opts.schema.Validate(settings)...)
+	}
+
+	return diagnostics
+}
+
+// transform applies the edits selected by opts to settings.
+func transform(settings []*format.Setting, opts options) []*format.Setting {
+	if opts.stripCommented {
+		settings = format.StripCommented(settings)
+	}
+
+	if opts.stripComments {
+		settings = format.StripComments(settings)
+	}
+
+	if opts.canonicalBools {
+		settings = format.CanonicalBools(settings, opts.schema)
+	}
+
+	if opts.normalizeURLs {
+		settings = format.NormalizeURLs(settings)
+	}
+
+	if opts.context != "" {
+		settings = format.Context(settings, opts.context)
+	}
+
+	if opts.trimEmpty {
+		settings = format.TrimEmpty(settings)
+	}
+
+	// SortWithOptions orders the variants when it sorts; -n leaves the
+	// settings in input order but still orders the variants within each.
+	if opts.noSort {
+		format.OrderVariants(settings, opts.sort)
+	}
+
+	return settings
+}
+
+// reportLongLines reports on stderr the variants of settings, which have
+// been transformed, whose formatted lines are longer than -max-line-length,
+// and reports whether there were any.
+func reportLongLines(stderr io.Writer, name string, settings []*format.Setting, opts options) bool {
+	if opts.maxLineLength <= 0 {
+		return false
+	}
+
+	diagnostics := format.LongLines(settings, opts.format, opts.maxLineLength)
+	reportDiagnostics(stderr, name, diagnostics)
+
+	return len(diagnostics) > 0
 }
 
-func sortSettings(settings []*Setting) {
-	sort.Slice(settings, func(i, j int) bool {
-		r1, r2 := rune(settings[i].Key[0]), rune(settings[j].Key[0])
-		if unicode.IsUpper(r1) != unicode.IsUpper(r2) {
-			return unicode.IsUpper(r1)
+// emit sorts and writes settings in the output form selected by opts and
+// reports whether the formatted output differs from src. The output goes to
+// stdout unless -w is given, in which case it replaces filename.
+func emit(filename, name string, src []byte, settings []*format.Setting, stdout, stderr io.Writer, opts options) (bool, error) {
+	if opts.count != nil {
+		keys, variants := format.Count(settings)
+		opts.count.Settings += keys
+		opts.count.Variants += variants
+
+		return false, nil
+	}
+
+	if opts.listKeys {
+		for _, key := range format.Keys(settings, opts.liveKeys) {
+			fmt.Fprintln(stdout, key)
+		}
+
+		return false, nil
+	}
+
+	var unsorted bytes.Buffer
+	if opts.stat {
+		if err := format.FormatWithOptions(&unsorted, settings, opts.format); err != nil {
+			return false, fmt.Errorf("error formatting file: %w", err)
+		}
+	}
+
+	if opts.eolAuto {
+		opts.format.CRLF = format.DetectCRLF(src)
+	}
+
+	var order []string
+	if opts.verbose {
+		order = variantOrder(settings)
+	}
+
+	if !opts.noSort {
+		format.SortWithOptions(settings, opts.sort)
+	}
+
+	// Merged output has no single input to report on.
+	if opts.verbose && name != "" {
+		reordered := !slices.Equal(order, variantOrder(settings))
+		fmt.Fprintf(stderr, "%s: %s\n", name, report(settings, !opts.noSort, reordered))
+	}
+
+	if opts.stat {
+		var buf bytes.Buffer
+		if err := format.FormatWithOptions(&buf, settings, opts.format); err != nil {
+			return false, fmt.Errorf("error formatting file: %w", err)
+		}
+
+		fmt.Fprintf(stdout, "%s: %s\n", name, newStat(settings, src, unsorted.Bytes(), buf.Bytes()))
+
+		return false, nil
+	}
+
+	if opts.json {
+		if err := format.EncodeJSON(stdout, settings); err != nil {
+			return false, fmt.Errorf("error writing JSON: %w", err)
+		}
+
+		return false, nil
+	}
+
+	if opts.toml {
+		if err := format.EncodeTOML(stdout, settings); err != nil {
+			return false, fmt.Errorf("error writing TOML: %w", err)
+		}
+
+		return false, nil
+	}
+
+	var buf bytes.Buffer
+	if err := format.FormatWithOptions(&buf, settings, opts.format); err != nil {
+		return false, fmt.Errorf("error formatting file: %w", err)
+	}
+
+	return output(filename, name, src, buf.Bytes(), stdout, opts)
+}
+
+// output lists, diffs, writes or prints the formatted text of an input as
+// selected by opts and reports whether it differs from src.
+func output(filename, name string, src, formatted []byte, stdout io.Writer, opts options) (bool, error) {
+	changed := !bytes.Equal(src, formatted)
+
+	if opts.list || opts.diff {
+		if opts.list && changed && (filename != "" || opts.stdinName != stdinLabel) {
+			fmt.Fprintln(stdout, name)
+		}
+
+		if opts.diff && changed {
+			diff := unifiedDiff(name, src, formatted)
+			if opts.color {
+				diff = colorDiff(diff)
+			}
+
+			if _, err := stdout.Write(diff); err != nil {
+				return changed, fmt.Errorf("error writing diff: %w", err)
+			}
+		}
+
+		return changed, nil
+	}
+
+	if opts.write {
+		if !changed {
+			// Rewriting would gain nothing, and a backup would replace the
+			// one holding the original with already formatted text.
+			return false, nil
+		}
+
+		if opts.backup {
+			if err := backupFile(filename, opts.backupSuffix, src); err != nil {
+				return changed, err
+			}
 		}
 
-		return settings[i].Key < settings[j].Key
+		return changed, writeFile(filename, func(w io.Writer) error {
+			_, err := w.Write(formatted)
+			return err
+		})
+	}
+
+	if _, err := stdout.Write(formatted); err != nil {
+		return changed, fmt.Errorf("error writing file: %w", err)
+	}
+
+	return changed, nil
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := f.Stat()
+
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// shown returns the diagnostics that opts reports: every one under -v or
+// -strict, and otherwise those more severe than SeverityInfo.
+func shown(diagnostics []format.Diagnostic, opts options) []format.Diagnostic {
+	if opts.strict || opts.verbose {
+		return diagnostics
+	}
+
+	return slices.DeleteFunc(diagnostics, func(d format.Diagnostic) bool {
+		return d.Severity == format.SeverityInfo
 	})
 }
+
+// reportDiagnostics writes diagnostics to w in line order, prefixed with the
+// name of the input they were found in and the line, if they have one.
+func reportDiagnostics(w io.Writer, name string, diagnostics []format.Diagnostic) {
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Line < diagnostics[j].Line
+	})
+
+	for _, d := range diagnostics {
+		if d.Line == 0 {
+			fmt.Fprintf(w, "%s: %s\n", name, d.Message)
+		} else {
+			fmt.Fprintf(w, "%s:%d: %s\n", name, d.Line, d.Message)
+		}
+	}
+}
+
+// widthValue is the -width flag. Like a boolean flag it may be given alone,
+// which aligns to the longest key, or with a value as -width=N.
+type widthValue struct {
+	opts *format.FormatOptions
+}
+
+func (v widthValue) String() string {
+	if v.opts == nil || v.opts.Width <= 0 {
+		return ""
+	}
+
+	return strconv.Itoa(v.opts.Width)
+}
+
+func (v widthValue) Set(s string) error {
+	if s == "true" {
+		v.opts.AlignAll = true
+		return nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid width %q: must be a positive number", s)
+	}
+
+	v.opts.Width = n
+
+	return nil
+}
+
+func (v widthValue) IsBoolFlag() bool {
+	return true
+}
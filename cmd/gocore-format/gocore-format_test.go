@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -9,81 +11,959 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func TestCleanMultiValues(t *testing.T) {
-	v := "1|2|3"
+func TestStdinName(t *testing.T) {
+	var stdout, stderr bytes.Buffer
 
-	cleaned := cleanMultiValues(v)
+	opts := options{verbose: true, list: true, stdinName: "buffer.conf"}
 
-	assert.Equal(t, "1 | 2 | 3", cleaned)
+	res, err := processFile("", strings.NewReader("a = 1\nnot a setting\n"), &stdout, &stderr, opts)
+	require.NoError(t, err)
+
+	assert.True(t, res.changed)
+	assert.Equal(t, "buffer.conf:2: \"not a setting\" is neither a setting nor a comment\n"+
+		"buffer.conf: 1 settings, 1 variants, 0 commented, sorting kept the order\n", stderr.String())
+	assert.Equal(t, "buffer.conf\n", stdout.String())
+}
+
+func TestStdinDefaultName(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	opts := options{verbose: true, list: true, stdinName: stdinLabel}
+
+	_, err := processFile("", strings.NewReader("not a setting\n"), &stdout, &stderr, opts)
+	require.NoError(t, err)
+
+	assert.Equal(t, "<standard input>:1: \"not a setting\" is neither a setting nor a comment\n"+
+		"<standard input>: 0 settings, 0 variants, 0 commented, sorting kept the order\n", stderr.String())
+	assert.Empty(t, stdout.String())
+}
+
+func TestWriteWithStdin(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	_, err := processFile("", strings.NewReader("a = 1\n"), &stdout, &stderr, options{write: true, stdinName: stdinLabel})
+	assert.EqualError(t, err, "cannot use -w when reading from stdin")
+
+	assert.Empty(t, stdout.String())
+}
+
+func TestRunNonexistentFile(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	err := run([]string{filepath.Join(t.TempDir(), "missing.conf")}, strings.NewReader(""), &stdout, &stderr)
+	require.Error(t, err)
+
+	assert.ErrorContains(t, err, "error opening file")
+	assert.NotEqual(t, 0, exitCode(err))
+	assert.Empty(t, stdout.String())
+}
+
+func TestRunExitCodes(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	err := run(nil, strings.NewReader("a = 1\n\n"), &stdout, &stderr)
+	require.NoError(t, err)
+	assert.Equal(t, 0, exitCode(err))
+	assert.Equal(t, "a = 1\n\n", stdout.String())
+
+	stdout.Reset()
+
+	err = run([]string{"-l"}, strings.NewReader("b=1\na=2\n"), &stdout, &stderr)
+	assert.ErrorIs(t, err, errUnformatted)
+	assert.Equal(t, 1, exitCode(err))
+
+	err = run([]string{"-strict"}, strings.NewReader("a = 1\na = 1\n"), &stdout, &stderr)
+	assert.Equal(t, 1, exitCode(err))
+
+	err = run([]string{"-no-such-flag"}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 1, exitCode(err))
+}
+
+func TestErrorsGoToStderr(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute(nil, strings.NewReader("a = 1\n= value\n"), &stdout, &stderr)
+
+	assert.Equal(t, 1, code)
+	assert.Empty(t, stdout.String())
+	assert.Equal(t, "error reading file: line 2: empty key in \"= value\"\n", stderr.String())
+}
+
+func TestWarningsGoToStderr(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-d"}, strings.NewReader("a = 1\na = 1\n"), &stdout, &stderr)
+
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "<standard input>:2: duplicate key \"a\" (first seen on line 1)\n", stderr.String())
+	assert.NotContains(t, stdout.String(), "duplicate")
+}
+
+func TestValidate(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-validate"}, strings.NewReader("foo..bar = 1\n"), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "<standard input>:1: invalid key \"foo..bar\": empty segment\n", stderr.String())
+
+	stderr.Reset()
+
+	code = execute([]string{"-validate", "-strict"}, strings.NewReader("foo..bar = 1\n"), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+
+	stderr.Reset()
+
+	// Without -validate only empty segments are reported.
+	code = execute(nil, strings.NewReader("foo-bar = 1\n"), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Empty(t, stderr.String())
+}
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+
+	a := filepath.Join(dir, "a.conf")
+	b := filepath.Join(dir, "b.conf")
+
+	require.NoError(t, os.WriteFile(a, []byte("db.host = a\ncache = 1\n"), 0644))
+	require.NoError(t, os.WriteFile(b, []byte("db.host = b\n"), 0644))
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-merge", a, b}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+
+	assert.Equal(t, "cache = 1\n\ndb.host = b\n\n", stdout.String())
+	assert.Equal(t, b+":1: \"db.host\" overrides the value from "+a+":1\n", stderr.String())
+
+	stderr.Reset()
+
+	code = execute([]string{"-merge", "-w", a, b}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "cannot use -merge with -w, -l or -d\n", stderr.String())
+}
+
+func TestMergeStrategyFlag(t *testing.T) {
+	dir := t.TempDir()
+
+	a := filepath.Join(dir, "a.conf")
+	b := filepath.Join(dir, "b.conf")
+
+	require.NoError(t, os.WriteFile(a, []byte("db.host = a\n"), 0644))
+	require.NoError(t, os.WriteFile(b, []byte("db.host = b\n"), 0644))
+
+	tests := []struct {
+		strategy string
+		code     int
+		stdout   string
+		stderr   string
+	}{
+		{
+			strategy: "last",
+			stdout:   "db.host = b\n\n",
+			stderr:   b + ":1: \"db.host\" overrides the value from " + a + ":1\n",
+		},
+		{
+			strategy: "first",
+			stdout:   "db.host = a\n\n",
+			stderr:   b + ":1: \"db.host\" is ignored, keeping the value from " + a + ":1\n",
+		},
+		{
+			strategy: "error",
+			code:     1,
+			stderr:   "merge conflict: \"db.host\" is \"a\" in " + a + ":1 but \"b\" in " + b + ":1\n",
+		},
+		{
+			strategy: "keep-both",
+			stdout:   "# db.host = a # overridden by " + b + "\ndb.host   = b\n\n",
+			stderr:   b + ":1: \"db.host\" overrides the value from " + a + ":1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			code := execute([]string{"-merge", "-merge-strategy", tt.strategy, a, b}, strings.NewReader(""), &stdout, &stderr)
+			assert.Equal(t, tt.code, code)
+			assert.Equal(t, tt.stdout, stdout.String())
+			assert.Equal(t, tt.stderr, stderr.String())
+		})
+	}
+}
+
+func TestStripCommented(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-strip-commented"}, strings.NewReader("a = 1\n# a.dev = 2\n# b = 1\n"), &stdout, &stderr)
+
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "a = 1\n\n", stdout.String())
+	assert.Empty(t, stderr.String())
+}
+
+func TestStripComments(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-strip-comments"}, strings.NewReader("# Section\na = 1 # one\n# b = 1\n"), &stdout, &stderr)
+
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "a = 1\n\n# b = 1\n\n", stdout.String())
+}
+
+func TestWidthFlag(t *testing.T) {
+	input := "a = 1\nlong.key = 2\n"
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-width"}, "a        = 1\n\nlong.key = 2\n\n"},
+		{[]string{"-width=3"}, "a   = 1\n\nlong.key = 2\n\n"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+
+		code := execute(tt.args, strings.NewReader(input), &stdout, &stderr)
+		assert.Equal(t, 0, code, stderr.String())
+		assert.Equal(t, tt.expected, stdout.String())
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-width=0"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "must be a positive number")
+}
+
+func TestCommentCharFlag(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-comment-char", ";"}, strings.NewReader("; Section\nb = 2 ;note\n;a = 1\n"), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "; a = 1\n\n; Section\nb = 2 ; note\n\n", stdout.String())
+
+	stderr.Reset()
+
+	code = execute([]string{"-comment-char", "=="}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "invalid comment character")
+}
+
+func TestCommentedTwinWarning(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	input := "a = 1\n# a = 1\n"
+
+	code := execute(nil, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "<standard input>:2: commented key \"a\" repeats the live value on line 1 and can be removed\n", stderr.String())
+
+	code = execute([]string{"-strict"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+}
+
+func TestContextFlag(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-context=prod"}, strings.NewReader("a = 1\na.prod = 2\nb = 3\nb.dev = 4\nc.dev = 5\n"), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "a = 2\n\nb = 3\n\n", stdout.String())
+}
+
+func TestConfDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "conf.d")
+	require.NoError(t, os.Mkdir(dir, 0755))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "20-local.conf"), []byte("db.host = local\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "10-base.conf"), []byte("db.host = base\nport = 1\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not = config\n"), 0644))
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{dir}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "db.host = local\n\nport = 1\n\n", stdout.String())
+	assert.Equal(t, filepath.Join(dir, "20-local.conf")+":1: \"db.host\" overrides the value from "+filepath.Join(dir, "10-base.conf")+":1\n", stderr.String())
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "30-bad.conf"), []byte(" = 1\n"), 0644))
+
+	stdout.Reset()
+	stderr.Reset()
+
+	code = execute([]string{dir}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), filepath.Join(dir, "30-bad.conf")+": ")
+}
+
+func TestConfDirectoryWrite(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "base.conf"), []byte("a=1\n"), 0644))
+
+	for _, flag := range []string{"-w", "-l", "-d"} {
+		t.Run(flag, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			code := execute([]string{flag, dir}, strings.NewReader(""), &stdout, &stderr)
+			assert.Equal(t, 1, code)
+			assert.Contains(t, stderr.String(), "cannot use -w, -l or -d with directory "+dir+", its files are merged into one output")
+			assert.NotContains(t, stderr.String(), "-merge")
+		})
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "base.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "a=1\n", string(data))
+}
+
+func TestListKeys(t *testing.T) {
+	input := "b = 1\n# a.dev = 2\na = 3\n"
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-list-keys"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "a\n#a.dev\nb\n", stdout.String())
+
+	stdout.Reset()
+
+	code = execute([]string{"-list-keys", "-keys-only-live"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "a\nb\n", stdout.String())
+}
+
+func TestUnbalancedQuoteWarning(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-strict"}, strings.NewReader("name = \"foo\n"), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "<standard input>:1: value of \"name\" has an unbalanced double quote\n")
+}
+
+func TestMaxLineLength(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	input := "hosts = alpha.example.com|beta.example.com\n"
+
+	code := execute([]string{"-max-line-length=30"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "<standard input>:1: line of \"hosts\" is 44 characters long, more than 30\n", stderr.String())
+
+	code = execute([]string{"-max-line-length=30", "-strict"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+
+	stderr.Reset()
+
+	// Lines are measured as written, after the comment is stripped.
+	code = execute([]string{"-max-line-length=20", "-strip-comments", "-strict"}, strings.NewReader("a = 1 # a comment that is long enough\n"), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Empty(t, stderr.String())
+}
+
+func TestMaxLineLengthMerge(t *testing.T) {
+	dir := t.TempDir()
+
+	a := filepath.Join(dir, "a.conf")
+	b := filepath.Join(dir, "b.conf")
+	require.NoError(t, os.WriteFile(a, []byte("hosts = alpha\n"), 0644))
+	require.NoError(t, os.WriteFile(b, []byte("hosts = alpha.example.com|beta.example.com\n"), 0644))
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-merge", "-max-line-length=30", a, b}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stderr.String(), "<merged output>: line of \"hosts\" is 44 characters long, more than 30\n")
+}
+
+func TestEOL(t *testing.T) {
+	tests := []struct {
+		args     []string
+		input    string
+		expected string
+	}{
+		{nil, "a = 1\r\n", "a = 1\n\n"},
+		{[]string{"-eol=lf"}, "a = 1\r\n", "a = 1\n\n"},
+		{[]string{"-eol=crlf"}, "a = 1\n", "a = 1\r\n\r\n"},
+		{[]string{"-eol=auto"}, "a = 1\r\nb = 2\r\nc = 3\n", "a = 1\r\n\r\nb = 2\r\n\r\nc = 3\r\n\r\n"},
+		{[]string{"-eol=auto"}, "a = 1\nb = 2\r\n", "a = 1\n\nb = 2\n\n"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+
+		code := execute(tt.args, strings.NewReader(tt.input), &stdout, &stderr)
+		assert.Equal(t, 0, code, stderr.String())
+		assert.Equal(t, tt.expected, stdout.String(), "%v %q", tt.args, tt.input)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schema := filepath.Join(t.TempDir(), "schema.json")
+	require.NoError(t, os.WriteFile(schema, []byte(`{"keys": {"port": {"type": "int"}, "host": {"required": true}}}`), 0644))
+
+	var stdout, stderr bytes.Buffer
+
+	input := "port = x\n"
+
+	code := execute([]string{"-schema", schema}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "<standard input>: missing required key \"host\"\n<standard input>:1: value \"x\" of \"port\" is not a valid int\n", stderr.String())
+	assert.Equal(t, "port = x\n\n", stdout.String())
+
+	code = execute([]string{"-schema", schema, "-strict"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+}
+
+func TestOnly(t *testing.T) {
+	input := `cache.ttl   =   60
+# Database
+db.port=5432
+db=main
+cache.size = 10   # kept verbatim
+db.host.prod = x|y
+`
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-only", "db"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, `cache.ttl   =   60
+# Database
+db.port      = 5432
+db           = main
+db.host.prod = x | y
+
+cache.size = 10   # kept verbatim
+`, stdout.String())
+
+	stdout.Reset()
+
+	code = execute([]string{"-only", "nothing"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, input, stdout.String())
+}
+
+func TestOnlyTwice(t *testing.T) {
+	tests := []struct {
+		name     string
+		only     string
+		input    string
+		expected string
+	}{
+		{name: "blank line kept", only: "db", input: "db = 1\n\ncache = 2\n", expected: "db = 1\n\ncache = 2\n"},
+		{name: "trailing comment", only: "a", input: "a = 1\n# x\n", expected: "a = 1\n\n# x\n"},
+		{name: "last setting", only: "b", input: "a=1\nb=2\n", expected: "a=1\nb = 2\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "settings.conf")
+			require.NoError(t, os.WriteFile(filename, []byte(tt.input), 0644))
+
+			var stdout, stderr bytes.Buffer
+
+			for i := 0; i < 2; i++ {
+				code := execute([]string{"-only", tt.only, "-w", filename}, strings.NewReader(""), &stdout, &stderr)
+				require.Equal(t, 0, code, stderr.String())
+			}
+
+			data, err := os.ReadFile(filename)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, string(data))
+
+			code := execute([]string{"-only", tt.only, "-l", filename}, strings.NewReader(""), &stdout, &stderr)
+			assert.Equal(t, 0, code)
+			assert.Empty(t, stdout.String())
+		})
+	}
+}
+
+func TestCanonicalBoolsFlag(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-canonical-bools"}, strings.NewReader("a = YES\nb = 1\n"), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "a = true\n\nb = 1\n\n", stdout.String())
 }
 
-func TestComments(t *testing.T) {
-	reader := strings.NewReader(`
-		a=2
-		A=1
-		#The following section is c
-		c=3 #this is the default value
-		c.dev=1
-		#c.test=2
-		c.prod=3
-	`)
+func TestGroupBySection(t *testing.T) {
+	var stdout, stderr bytes.Buffer
 
-	settings, err := readSettings(reader)
+	input := "# [B]\nz = 1\ny = 2\n# [A]\nx = 3\n"
+
+	code := execute([]string{"-group-by-section"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "# [B]\n\ny = 2\n\nz = 1\n\n# [A]\n\nx = 3\n\n", stdout.String())
+}
+
+func TestNoAlignFlag(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-no-align"}, strings.NewReader("b=2\na.long.key   =  1\na = 3\n"), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "a.long.key = 1\na = 3\n\nb = 2\n\n", stdout.String())
+}
+
+func TestTabWidthFlag(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-tab-width", "2"}, strings.NewReader("\ta\t=\tx\ty\n"), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "a = x  y\n\n", stdout.String())
+}
+
+func TestColorFlag(t *testing.T) {
+	for _, test := range []struct {
+		args  []string
+		color bool
+	}{
+		{[]string{"-d", "-color=never"}, false},
+		{[]string{"-d", "-color=always"}, true},
+		// A buffer is never a terminal.
+		{[]string{"-d"}, false},
+	} {
+		var stdout, stderr bytes.Buffer
+
+		code := execute(test.args, strings.NewReader("b=1\na=2\n"), &stdout, &stderr)
+		assert.Equal(t, 1, code, stderr.String())
+		assert.Contains(t, stdout.String(), "+a = 2")
+		assert.Equal(t, test.color, strings.Contains(stdout.String(), "\x1b["), "%v", test.args)
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-color=sometimes"}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), `invalid color mode "sometimes"`)
+}
+
+func TestDedupeFlag(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	input := "# host\na.host = 1\nb = 2\n# host\n# port\na.port = 3\n"
+
+	code := execute([]string{"-dedupe"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "# host\n\n# port\na.host = 1\na.port = 3\n\nb = 2\n\n", stdout.String())
+}
+
+func TestSeparatorFlag(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-separator", ": ", "-no-align"}, strings.NewReader("b:2\na.long : 1\na=3\n"), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	// A line split on '=' is no longer a setting and is kept as a comment.
+	assert.Equal(t, "a.long: 1\n\nb: 2\n\n# a=3\n\n", stdout.String())
+
+	stdout.Reset()
+	stderr.Reset()
+
+	code = execute([]string{"-separator", "#"}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "cannot use the comment character as the separator\n", stderr.String())
+}
+
+func TestQuiet(t *testing.T) {
+	input := "a = 1\na = 1\n"
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-v"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stderr.String(), `duplicate key "a"`)
+
+	stdout.Reset()
+	stderr.Reset()
+
+	code = execute([]string{"-q", "-v"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Empty(t, stderr.String())
+	assert.Equal(t, "a = 1\na = 1\n\n", stdout.String())
+
+	// -strict still fails, with only the error itself on stderr.
+	stdout.Reset()
+	stderr.Reset()
+
+	code = execute([]string{"-q", "-strict"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "warnings reported with -strict\n", stderr.String())
+}
+
+func TestLiveFirstFlag(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-live-first"}, strings.NewReader("# x.dev = a\nx.prod = b\n# x.test = c\n"), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "x.prod   = b\n# x.dev  = a\n# x.test = c\n\n", stdout.String())
+}
+
+func TestVariantOrderWithoutSort(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "settings.conf")
+	require.NoError(t, os.WriteFile(filename, []byte("b.prod = 2\nb = 1\n# a.dev = 2\na = 1\n"), 0644))
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-n", "-live-first"}, "b.prod = 2\nb      = 1\n\na       = 1\n# a.dev = 2\n\n"},
+		{[]string{"-n", "-sort-variants"}, "b      = 1\nb.prod = 2\n\na       = 1\n# a.dev = 2\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			// From stdin the file is read whole; from a file it is streamed.
+			for _, args := range [][]string{tt.args, append(tt.args, filename)} {
+				var stdout, stderr bytes.Buffer
+
+				code := execute(args, strings.NewReader("b.prod = 2\nb = 1\n# a.dev = 2\na = 1\n"), &stdout, &stderr)
+				require.Equal(t, 0, code, stderr.String())
+				assert.Equal(t, tt.expected, stdout.String())
+			}
+		})
+	}
+}
+
+func TestTemplateFlag(t *testing.T) {
+	dir := t.TempDir()
+
+	base := filepath.Join(dir, "base.conf")
+	require.NoError(t, os.WriteFile(base, []byte("url = http://{{HOST}}:{{PORT}}\nname={{NAME}}\n"), 0644))
+
+	values := filepath.Join(dir, "prod.env")
+	require.NoError(t, os.WriteFile(values, []byte("HOST=prod.example.com\nPORT=443\n"), 0644))
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-template", base, "-values", values}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, `error filling template: line 2: no value for placeholder "NAME"`+"\n", stderr.String())
+	assert.Empty(t, stdout.String())
+
+	stderr.Reset()
+
+	code = execute([]string{"-allow-missing", "-values", values, "-template", base}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "name = {{NAME}}\n\nurl = http://prod.example.com:443\n\n", stdout.String())
+
+	stdout.Reset()
+
+	code = execute([]string{"-template", base}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "-template needs a -values file\n", stderr.String())
+
+	stderr.Reset()
+
+	code = execute([]string{"-w", "-values", values, "-template", base}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "cannot use -w or -watch with -template")
+}
+
+func TestStampFlag(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "settings.conf")
+	require.NoError(t, os.WriteFile(filename, []byte("b = 2\na = 1\n"), 0644))
+
+	for i := 0; i < 2; i++ {
+		var stdout, stderr bytes.Buffer
+
+		code := execute([]string{"-w", "-stamp", filename}, strings.NewReader(""), &stdout, &stderr)
+		require.Equal(t, 0, code, stderr.String())
+	}
+
+	data, err := os.ReadFile(filename)
 	require.NoError(t, err)
 
-	sortSettings(settings)
+	assert.Equal(t, 1, strings.Count(string(data), "formatted by gocore-format"))
+	assert.Equal(t, "# formatted by gocore-format v1\n\na = 1\n\nb = 2\n\n", string(data))
+}
+
+func TestTrimEmptySettingsFlag(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-strip-commented", "-trim-empty-settings"}, strings.NewReader("a = 1\n# b = 2\n# the end\n"), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "a = 1\n\n", stdout.String())
+}
+
+func TestFailOnWarnings(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "settings.conf")
+
+	for _, test := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"-w"}, 0},
+		{[]string{"-w", "-fail-on-warnings"}, 1},
+	} {
+		require.NoError(t, os.WriteFile(filename, []byte("name = \"open\nb = 1\nnot a setting\n"), 0644))
+
+		var stdout, stderr bytes.Buffer
+
+		code := execute(append(test.args, filename), strings.NewReader(""), &stdout, &stderr)
+		assert.Equal(t, test.code, code, "%v", test.args)
+		assert.Contains(t, stderr.String(), `unbalanced double quote`)
+
+		// Malformed lines are only reported with -v or -strict.
+		assert.NotContains(t, stderr.String(), "neither a setting nor a comment")
+
+		if test.code != 0 {
+			assert.Contains(t, stderr.String(), "warnings reported with -fail-on-warnings\n")
+		}
 
-	// Write settings to a string buffer
-	buf := &bytes.Buffer{}
-	err = writeSettings(buf, settings)
+		// The file is formatted either way.
+		data, err := os.ReadFile(filename)
+		require.NoError(t, err)
+		assert.Equal(t, "b = 1\n\nname = \"open\n\n# not a setting\n\n", string(data))
+	}
+}
+
+func TestRawValuesFlag(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-raw-values"}, strings.NewReader("art = `/\\  |  /\\ #`\n"), &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "art = `/\\  |  /\\ #`\n\n", stdout.String())
+}
+
+func TestLintTyposFlag(t *testing.T) {
+	input := "timeout = 1\ntimeut = 2\nhost = a\n"
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute(nil, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Empty(t, stderr.String())
+
+	code = execute([]string{"-lint-typos"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, `<standard input>:2: root key "timeut" is similar to "timeout" on line 1, is it a typo?`+"\n", stderr.String())
+}
+
+func TestEmptySegmentWarning(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute(nil, strings.NewReader("foo. .bar = 1\n"), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "foo..bar = 1\n\n", stdout.String())
+	assert.Equal(t, "<standard input>:1: key \"foo..bar\" has an empty segment\n", stderr.String())
+}
+
+func TestSetFlag(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "settings.conf")
+	require.NoError(t, os.WriteFile(filename, []byte("# The database\ndb.host = localhost\ndb.port = 5432 # default\n"), 0644))
+
+	var stdout, stderr bytes.Buffer
+
+	// Update an existing key, add a context to it and add a new setting.
+	code := execute([]string{"-set", "db.port=6432", "-set", "db.host.prod = example.com # primary", "-set", "cache=on", "-w", filename}, strings.NewReader(""), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+
+	data, err := os.ReadFile(filename)
 	require.NoError(t, err)
+	assert.Equal(t, `cache = on
 
-	assert.Equal(t, 3, len(settings))
-	assert.Equal(t, `A = 1
+# The database
+db.host      = localhost
+db.port      = 6432 # default
+db.host.prod = example.com # primary
 
-a = 2
+`, string(data))
 
-# The following section is c
-c        = 3 # this is the default value
-c.dev    = 1
-# c.test = 2
-c.prod   = 3
+	// Later -set flags win.
+	stdout.Reset()
+	code = execute([]string{"-set", "a=1", "-set", "a=2"}, strings.NewReader(""), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "a = 2\n\n", stdout.String())
 
-`, buf.String())
+	stderr.Reset()
+	code = execute([]string{"-set", "db.host"}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "invalid -set: \"db.host\" is not a key=value pair\n", stderr.String())
 }
 
-func TestProcessLine(t *testing.T) {
-	test := []struct {
-		line string
-		want *Variant
+func TestUnsetFlag(t *testing.T) {
+	const input = `# The database
+db.host = localhost
+db.host.prod = example.com
+# db.host.prod = old
+db.port = 5432
+`
+
+	tests := []struct {
+		name   string
+		args   []string
+		want   string
+		stderr string
+		code   int
 	}{
 		{
-			line: "#a=b",
-			want: &Variant{
-				Commented: true,
-				Key:       "a",
-				Value:     "b",
-			},
+			name: "context",
+			args: []string{"-unset", "db.host.prod"},
+			want: "# The database\ndb.host = localhost\ndb.port = 5432\n\n",
+		},
+		{
+			name: "base",
+			args: []string{"-unset", "db.host", "-unset", "db.port"},
+			want: "# The database\ndb.host.prod   = example.com\n# db.host.prod = old\n\n",
 		},
 		{
-			line: "a=b #comment",
-			want: &Variant{
-				Commented: false,
-				Key:       "a",
-				Value:     "b",
-				Comment:   "comment",
-			},
+			name: "whole setting",
+			args: []string{"-unset", "db.host", "-unset", "db.host.prod", "-unset", "db.port", "-set", "a=1"},
+			want: "a = 1\n\n",
 		},
 		{
-			line: "#comment",
-			want: nil,
+			name: "after set",
+			args: []string{"-set", "db.user=admin", "-unset", "db.user"},
+			want: "# The database\ndb.host        = localhost\ndb.host.prod   = example.com\n# db.host.prod = old\ndb.port        = 5432\n\n",
+		},
+		{
+			name:   "missing",
+			args:   []string{"-unset", "cache"},
+			want:   "# The database\ndb.host        = localhost\ndb.host.prod   = example.com\n# db.host.prod = old\ndb.port        = 5432\n\n",
+			stderr: "<standard input>: -unset \"cache\" matched no variant\n",
+		},
+		{
+			name:   "missing with -fail-on-warnings",
+			args:   []string{"-fail-on-warnings", "-unset", "cache"},
+			want:   "# The database\ndb.host        = localhost\ndb.host.prod   = example.com\n# db.host.prod = old\ndb.port        = 5432\n\n",
+			stderr: "<standard input>: -unset \"cache\" matched no variant\nwarnings reported with -fail-on-warnings\n",
+			code:   1,
 		},
 	}
 
-	for _, tt := range test {
-		t.Run(tt.line, func(t *testing.T) {
-			setting := processLine(tt.line)
-			assert.Equal(t, tt.want, setting)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			code := execute(tt.args, strings.NewReader(input), &stdout, &stderr)
+			assert.Equal(t, tt.code, code)
+			assert.Equal(t, tt.want, stdout.String())
+			assert.Equal(t, tt.stderr, stderr.String())
 		})
 	}
 }
+
+func TestCommentFlags(t *testing.T) {
+	const input = "feature.x = on\nfeature.x.dev = off\nfeature.xy = on\n"
+
+	var stdout, stderr bytes.Buffer
+
+	// Only the full key is matched, not feature.xy or feature.x.dev.
+	code := execute([]string{"-comment", "feature.x"}, strings.NewReader(input), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+
+	commented := "# feature.x   = on\nfeature.x.dev = off\nfeature.xy    = on\n\n"
+	assert.Equal(t, commented, stdout.String())
+
+	stdout.Reset()
+	code = execute([]string{"-uncomment", "feature.x"}, strings.NewReader(commented), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "feature.x     = on\nfeature.x.dev = off\nfeature.xy    = on\n\n", stdout.String())
+
+	stdout.Reset()
+	code = execute([]string{"-uncomment", "feature.z"}, strings.NewReader(input), &stdout, &stderr)
+	require.Equal(t, 0, code)
+	assert.Equal(t, "<standard input>: -uncomment \"feature.z\" matched no variant\n", stderr.String())
+}
+
+func TestEmptyValueWarning(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-fail-on-warnings"}, strings.NewReader("# a.dev =\na = 1\n"), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Empty(t, stderr.String())
+
+	code = execute([]string{"-fail-on-warnings"}, strings.NewReader("a =\n"), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "<standard input>:1: value of \"a\" is empty\nwarnings reported with -fail-on-warnings\n", stderr.String())
+}
+
+func TestNormalizeURLsFlag(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-normalize-urls"}, strings.NewReader("api = HTTP://Example.com:80/\nhosts = Alpha | HTTPS://Beta.com:443/\n"), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "api = http://example.com\n\nhosts = Alpha | https://beta.com\n\n", stdout.String())
+}
+
+func TestCountFlag(t *testing.T) {
+	dir := t.TempDir()
+
+	a := filepath.Join(dir, "a.conf")
+	b := filepath.Join(dir, "b.conf")
+
+	require.NoError(t, os.WriteFile(a, []byte("# The database\ndb = a\ndb.prod = b\n# db.dev = c\ncache = 1\n# Trailing\n"), 0644))
+	require.NoError(t, os.WriteFile(b, []byte("port = 80\n"), 0644))
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-count", a}, strings.NewReader(""), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "2 4\n", stdout.String())
+
+	// Totals are summed over every input.
+	stdout.Reset()
+	code = execute([]string{"-count", "-json", a, b}, strings.NewReader(""), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, `{"settings":3,"variants":5}`+"\n", stdout.String())
+
+	stdout.Reset()
+	code = execute([]string{"-count", "-w", a}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Empty(t, stdout.String())
+	assert.Equal(t, "cannot use -count with -w, -l, -d, -watch, -stat, -list-keys, -toml or -profile\n", stderr.String())
+}
+
+func TestFormatFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "settings.conf")
+	require.NoError(t, os.WriteFile(filename, []byte("b=2\na=1\n"), 0644))
+
+	var stdout, stderr bytes.Buffer
+
+	res, err := formatFile(filename, &stdout, &stderr, options{noSort: true})
+	require.NoError(t, err)
+	assert.True(t, res.streamed)
+	assert.Equal(t, "b = 2\n\na = 1\n\n", stdout.String())
+
+	stdout.Reset()
+
+	// -l needs the whole file to tell whether it changed.
+	res, err = formatFile(filename, &stdout, &stderr, options{noSort: true, list: true})
+	require.NoError(t, err)
+	assert.False(t, res.streamed)
+	assert.True(t, res.changed)
+	assert.Equal(t, filename+"\n", stdout.String())
+}
+
+func TestIncludeAfterFormat(t *testing.T) {
+	dir := t.TempDir()
+
+	base := filepath.Join(dir, "base.conf")
+	main := filepath.Join(dir, "main.conf")
+	require.NoError(t, os.WriteFile(base, []byte("port = 1\n"), 0644))
+	require.NoError(t, os.WriteFile(main, []byte("name=app\n#include base.conf\n@include base.conf\n"), 0644))
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-w", main}, strings.NewReader(""), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+
+	data, err := os.ReadFile(main)
+	require.NoError(t, err)
+	assert.Equal(t, "name = app\n\n#include base.conf\n\n@include base.conf\n\n", string(data))
+
+	code = execute([]string{"-include", "-list-keys", main}, strings.NewReader(""), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "name\nport\n", stdout.String())
+}
+
+func TestFromJSONInjection(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	input := `[{"key":"a","variants":[{"key":"a","value":"1\nevil = 2"}]}]`
+
+	code := execute([]string{"-from-json"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Empty(t, stdout.String())
+	assert.Contains(t, stderr.String(), `setting 1 ("a"): variant 1 ("a") has a line break in its value`)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ordishs/gocore-format/format"
+)
+
+// writeProfiles writes, for each file and each -profile, the effective
+// settings of that context to a file in -out-dir, or next to the input if
+// none is given. The output for settings.conf and profile dev is
+// settings.dev.conf.
+func writeProfiles(filenames []string, stdout, stderr io.Writer, opts options) (result, error) {
+	var res result
+
+	if len(filenames) == 0 {
+		return res, errors.New("cannot use -profile when reading from stdin")
+	}
+
+	if opts.write || opts.list || opts.diff || opts.merge || opts.stat || opts.listKeys || opts.json || opts.toml || opts.watch || len(opts.only) > 0 || opts.context != "" {
+		return res, errors.New("cannot use -profile with -w, -l, -d, -merge, -stat, -list-keys, -json, -toml, -watch, -only or -context")
+	}
+
+	if opts.outDir != "" {
+		if err := os.MkdirAll(opts.outDir, 0755); err != nil {
+			return res, fmt.Errorf("error creating output directory: %w", err)
+		}
+	}
+
+	for _, filename := range filenames {
+		src, err := os.ReadFile(filename)
+		if err != nil {
+			return res, fmt.Errorf("error reading file: %w", err)
+		}
+
+		settings, warned, err := readSettings(filename, src, stderr, opts)
+		if err != nil {
+			return res, err
+		}
+
+		res.warned = res.warned || warned
+
+		for _, profile := range opts.profiles {
+			o := opts
+			o.context = profile
+
+			// Context rewrites the settings it is given, so each profile
+			// starts from a copy.
+			profiled := transform(cloneSettings(settings), o)
+
+			res.warned = reportLongLines(stderr, filename, profiled, o) || res.warned
+
+			var buf bytes.Buffer
+			if _, err := emit("", "", src, profiled, &buf, stderr, o); err != nil {
+				return res, err
+			}
+
+			out := profilePath(filename, opts.outDir, profile)
+
+			if err := writeFile(out, func(w io.Writer) error {
+				_, err := w.Write(buf.Bytes())
+				return err
+			}); err != nil {
+				return res, err
+			}
+
+			fmt.Fprintln(stdout, out)
+		}
+	}
+
+	return res, nil
+}
+
+// profilePath returns the name of the file written for profile from
+// filename, in dir if it is not empty.
+func profilePath(filename, dir, profile string) string {
+	if dir == "" {
+		dir = filepath.Dir(filename)
+	}
+
+	base := filepath.Base(filename)
+	ext := filepath.Ext(base)
+
+	return filepath.Join(dir, strings.TrimSuffix(base, ext)+"."+profile+ext)
+}
+
+// cloneSettings returns a copy of settings that shares nothing that the
+// transformations and sorting change.
+func cloneSettings(settings []*format.Setting) []*format.Setting {
+	clone := make([]*format.Setting, 0, len(settings))
+
+	for _, setting := range settings {
+		s := *setting
+		s.Variants = slices.Clone(setting.Variants)
+
+		clone = append(clone, &s)
+	}
+
+	return clone
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+
+	filename := filepath.Join(dir, "settings.conf")
+	require.NoError(t, os.WriteFile(filename, []byte(`# The database
+db = localhost
+db.prod = db.example.com
+# db.dev = old
+port = 80
+port.dev = 8080
+`), 0644))
+
+	out := filepath.Join(dir, "gen")
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-profile", "dev", "-profile", "prod", "-out-dir", out, filename}, strings.NewReader(""), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+
+	dev := filepath.Join(out, "settings.dev.conf")
+	prod := filepath.Join(out, "settings.prod.conf")
+
+	assert.Equal(t, dev+"\n"+prod+"\n", stdout.String())
+
+	data, err := os.ReadFile(dev)
+	require.NoError(t, err)
+	assert.Equal(t, "# The database\ndb = localhost\n\nport = 8080\n\n", string(data))
+
+	data, err = os.ReadFile(prod)
+	require.NoError(t, err)
+	assert.Equal(t, "# The database\ndb = db.example.com\n\nport = 80\n\n", string(data))
+
+	// The master file is left alone.
+	data, err = os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "port.dev = 8080")
+}
+
+func TestProfilePath(t *testing.T) {
+	assert.Equal(t, filepath.Join("conf", "settings.dev.conf"), profilePath(filepath.Join("conf", "settings.conf"), "", "dev"))
+	assert.Equal(t, filepath.Join("gen", "settings.prod.conf"), profilePath("settings.conf", "gen", "prod"))
+	assert.Equal(t, filepath.Join("gen", "settings.prod"), profilePath("settings", "gen", "prod"))
+}
+
+func TestProfileStdin(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-profile", "dev"}, strings.NewReader("a = 1\n"), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "cannot use -profile when reading from stdin\n", stderr.String())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ordishs/gocore-format/format"
+)
+
+// stat summarizes for -stat how formatting would change an input.
+type stat struct {
+	settings  int
+	variants  int
+	moved     int
+	canonical bool
+}
+
+// newStat counts settings and their variants and compares the formatted
+// output before and after sorting, and with the original src.
+func newStat(settings []*format.Setting, src, unsorted, sorted []byte) stat {
+	s := stat{canonical: bytes.Equal(src, sorted)}
+
+	s.settings, s.variants, _ = count(settings)
+
+	for _, op := range diffLines(splitLines(unsorted), splitLines(sorted)) {
+		if op.kind == '-' {
+			s.moved++
+		}
+	}
+
+	return s
+}
+
+func (s stat) String() string {
+	state := "not canonical"
+	if s.canonical {
+		state = "canonical"
+	}
+
+	return fmt.Sprintf("%d settings, %d variants, %d lines moved by sorting, %s", s.settings, s.variants, s.moved, state)
+}
+
+// count returns the number of settings with variants, of variants, and of
+// commented-out variants.
+func count(settings []*format.Setting) (withVariants, variants, commented int) {
+	for _, setting := range settings {
+		if len(setting.Variants) > 0 {
+			withVariants++
+			variants += len(setting.Variants)
+		}
+
+		for _, variant := range setting.Variants {
+			if variant.Commented {
+				commented++
+			}
+		}
+	}
+
+	return withVariants, variants, commented
+}
+
+// variantOrder lists the variants of settings in order, so that the order
+// before and after sorting can be compared.
+func variantOrder(settings []*format.Setting) []string {
+	var order []string
+
+	for _, setting := range settings {
+		for _, variant := range setting.Variants {
+			order = append(order, fmt.Sprintf("%d:%t:%s", variant.Line, variant.Commented, variant.Key))
+		}
+	}
+
+	return order
+}
+
+// report returns the -v summary of settings, which sorting reordered if
+// reordered is set.
+func report(settings []*format.Setting, sorted, reordered bool) string {
+	withVariants, variants, commented := count(settings)
+
+	order := "not sorted"
+	switch {
+	case reordered:
+		order = "sorting changed the order"
+	case sorted:
+		order = "sorting kept the order"
+	}
+
+	return fmt.Sprintf("%d settings, %d variants, %d commented, %s", withVariants, variants, commented, order)
+}
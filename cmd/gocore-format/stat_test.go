@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStat(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	input := "b = 2\n# b.dev = 3\na = 1\n# footer\n"
+
+	code := execute([]string{"-stat"}, strings.NewReader(input), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Empty(t, stderr.String())
+	assert.Equal(t, "<standard input>: 2 settings, 3 variants, 2 lines moved by sorting, not canonical\n", stdout.String())
+
+	stdout.Reset()
+
+	code = execute([]string{"-stat"}, strings.NewReader("a = 1\n\nb = 2\n\n"), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "<standard input>: 2 settings, 2 variants, 0 lines moved by sorting, canonical\n", stdout.String())
+}
+
+func TestStatDoesNotWrite(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "settings.conf")
+	require.NoError(t, os.WriteFile(filename, []byte("b=2\na=1\n"), 0644))
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-stat", filename}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, filename+": 2 settings, 2 variants, 2 lines moved by sorting, not canonical\n", stdout.String())
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "b=2\na=1\n", string(data))
+
+	stdout.Reset()
+
+	code = execute([]string{"-stat", "-w", filename}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "cannot use -stat with -w\n", stderr.String())
+}
+
+func TestVerboseReport(t *testing.T) {
+	tests := []struct {
+		args     []string
+		input    string
+		expected string
+	}{
+		{[]string{"-v"}, "b = 2\n# b.dev = 3\na = 1\n", "<standard input>: 2 settings, 3 variants, 1 commented, sorting changed the order\n"},
+		{[]string{"-v"}, "a = 1\nb = 2\n", "<standard input>: 2 settings, 2 variants, 0 commented, sorting kept the order\n"},
+		{[]string{"-v", "-n"}, "b = 2\na = 1\n", "<standard input>: 2 settings, 2 variants, 0 commented, not sorted\n"},
+		{[]string{"-v", "-sort-variants"}, "a.prod = 2\na = 1\n", "<standard input>: 1 settings, 2 variants, 0 commented, sorting changed the order\n"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+
+		code := execute(tt.args, strings.NewReader(tt.input), &stdout, &stderr)
+		assert.Equal(t, 0, code)
+		assert.Equal(t, tt.expected, stderr.String(), "%v %q", tt.args, tt.input)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"github.com/ordishs/gocore-format/format"
+)
+
+// canStream reports whether a file can be formatted with format.FormatStream
+// under opts: settings are not sorted and every other option given is one
+// known to need only one setting at a time. Any option not listed here,
+// including one added later, has the file read whole.
+func canStream(opts options) bool {
+	if !opts.noSort || (opts.format.AlignAll && opts.format.Width == 0) {
+		return false
+	}
+
+	safe := options{
+		write:          opts.write,
+		output:         opts.output,
+		noSort:         opts.noSort,
+		strict:         opts.strict,
+		failOnWarnings: opts.failOnWarnings,
+		stdinName:      opts.stdinName,
+		foldCase:       opts.foldCase,
+		validate:       opts.validate,
+		mergeStrategy:  opts.mergeStrategy,
+		stripCommented: opts.stripCommented,
+		stripComments:  opts.stripComments,
+		context:        opts.context,
+		maxLineLength:  opts.maxLineLength,
+		backupSuffix:   opts.backupSuffix,
+		canonicalBools: opts.canonicalBools,
+		normalizeURLs:  opts.normalizeURLs,
+		trimEmpty:      opts.trimEmpty,
+		color:          opts.color,
+		dedupe:         opts.dedupe,
+		separator:      opts.separator,
+		quiet:          opts.quiet,
+		sort:           opts.sort,
+		format:         opts.format,
+	}
+
+	return reflect.DeepEqual(safe, opts)
+}
+
+// streamFile formats filename one setting at a time, so that a large file
+// is never held in memory whole, and reports whether it did. Under -w the
+// file is only replaced if its formatting differs. It does not if
+// a root key of the file is split by others, as only the settings of the
+// whole file can be grouped then; a first pass that writes nothing finds
+// out.
+func streamFile(filename string, stdout, stderr io.Writer, opts options) (result, bool, error) {
+	var res result
+
+	in, err := os.Open(filename)
+	if err != nil {
+		return res, false, fmt.Errorf("error opening file: %w", err)
+	}
+	defer in.Close()
+
+	parseOpts := format.ParseOptions{
+		CaseInsensitiveGroup: opts.foldCase,
+		CommentChar:          opts.format.CommentChar,
+		Separator:            opts.separator,
+		RawValues:            opts.format.RawValues,
+		Sections:             opts.sort.BySection,
+		DedupeComments:       opts.dedupe,
+		Filename:             filename,
+	}
+
+	if err := format.FormatStream(io.Discard, in, parseOpts, opts.format, nil); err != nil {
+		if errors.Is(err, format.ErrNotContiguous) {
+			return res, false, nil
+		}
+
+		return res, false, fmt.Errorf("error reading file: %w", err)
+	}
+
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return res, false, fmt.Errorf("error reading file: %w", err)
+	}
+
+	var diagnostics []format.Diagnostic
+
+	parseOpts.Warn = func(d format.Diagnostic) {
+		diagnostics = append(diagnostics, d)
+	}
+
+	first := true
+
+	// each reports the diagnostics that readSettings would for the setting
+	// and the lines read with it, transforms it and reports its long lines.
+	each := func(settings []*format.Setting) ([]*format.Setting, error) {
+		if first && opts.format.Stamp {
+			settings = format.StripStamp(settings)
+		}
+
+		first = false
+
+		diagnostics = append(diagnostics, format.Lint(settings)...)
+		diagnostics = shown(lint(diagnostics, settings, opts), opts)
+
+		res.warned = res.warned || len(diagnostics) > 0
+
+		reportDiagnostics(stderr, filename, diagnostics)
+		diagnostics = diagnostics[:0]
+
+		settings = transform(settings, opts)
+
+		res.warned = reportLongLines(stderr, filename, settings, opts) || res.warned
+
+		return settings, nil
+	}
+
+	// The output is compared with the file as it is written, through a
+	// second handle, as the first is being read by the parser.
+	orig, err := os.Open(filename)
+	if err != nil {
+		return res, false, fmt.Errorf("error opening file: %w", err)
+	}
+	defer orig.Close()
+
+	stream := func(w io.Writer) error {
+		cw := &compareWriter{w: w, r: orig}
+
+		if err := format.FormatStream(cw, in, parseOpts, opts.format, each); err != nil {
+			return fmt.Errorf("error formatting file: %w", err)
+		}
+
+		res.changed = cw.changed()
+
+		if opts.write && !res.changed {
+			return errUnchanged
+		}
+
+		return nil
+	}
+
+	switch {
+	case opts.write:
+		// As in output, a file that is already formatted is left alone.
+		if err := writeFile(filename, stream); err != nil && !errors.Is(err, errUnchanged) {
+			return res, true, err
+		}
+
+		return res, true, nil
+	case opts.output != "":
+		return res, true, writeFile(opts.output, stream)
+	}
+
+	return res, true, stream(stdout)
+}
+
+// errUnchanged stops writeFile from replacing a file with the same bytes.
+var errUnchanged = errors.New("file is unchanged")
+
+// compareWriter passes writes on to w and notes whether they differ from
+// what r holds.
+type compareWriter struct {
+	w       io.Writer
+	r       io.Reader
+	buf     []byte
+	differs bool
+}
+
+func (c *compareWriter) Write(p []byte) (int, error) {
+	if !c.differs {
+		if cap(c.buf) < len(p) {
+			c.buf = make([]byte, len(p))
+		}
+
+		n, _ := io.ReadFull(c.r, c.buf[:len(p)])
+		c.differs = !bytes.Equal(c.buf[:n], p)
+	}
+
+	return c.w.Write(p)
+}
+
+// changed reports whether what was written differs from r, including by r
+// holding more.
+func (c *compareWriter) changed() bool {
+	if !c.differs {
+		var b [1]byte
+
+		n, _ := io.ReadFull(c.r, b[:])
+		c.differs = n > 0
+	}
+
+	return c.differs
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ordishs/gocore-format/format"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamFile(t *testing.T) {
+	const input = `# The port
+port=80
+port=8080
+# port.dev = 81
+
+name.dev="app
+this is not a setting
+# Trailing comment
+`
+
+	filename := filepath.Join(t.TempDir(), "settings.conf")
+	require.NoError(t, os.WriteFile(filename, []byte(input), 0644))
+
+	opts := options{noSort: true, strict: true, stripCommented: true}
+	require.True(t, canStream(opts))
+
+	var streamed, streamedErr bytes.Buffer
+
+	res, ok, err := streamFile(filename, &streamed, &streamedErr, opts)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.True(t, res.warned)
+
+	var buffered, bufferedErr bytes.Buffer
+
+	_, err = processFile(filename, strings.NewReader(input), &buffered, &bufferedErr, opts)
+	require.NoError(t, err)
+
+	assert.Equal(t, buffered.String(), streamed.String())
+	assert.Equal(t, bufferedErr.String(), streamedErr.String())
+	assert.Contains(t, streamedErr.String(), filename+":3: ")
+}
+
+func TestStreamFileNotContiguous(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "settings.conf")
+	require.NoError(t, os.WriteFile(filename, []byte("a=1\nb=2\na.dev=3\n"), 0644))
+
+	var stdout, stderr bytes.Buffer
+
+	_, ok, err := streamFile(filename, &stdout, &stderr, options{noSort: true})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Empty(t, stdout.String())
+
+	// The buffered path groups the variants of a instead.
+	code := execute([]string{"-n", filename}, strings.NewReader(""), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "a     = 1\na.dev = 3\n\nb = 2\n\n", stdout.String())
+}
+
+func TestStreamWrite(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "settings.conf")
+	require.NoError(t, os.WriteFile(filename, []byte("b=2\na=1\n"), 0644))
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-n", "-w", filename}, strings.NewReader(""), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "b = 2\n\na = 1\n\n", string(data))
+}
+
+func TestStreamWriteUnchanged(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "settings.conf")
+	require.NoError(t, os.WriteFile(filename, []byte("a = 1\n\n"), 0644))
+
+	before, err := os.Stat(filename)
+	require.NoError(t, err)
+
+	var stdout, stderr bytes.Buffer
+
+	res, streamed, err := streamFile(filename, &stdout, &stderr, options{noSort: true, write: true})
+	require.NoError(t, err)
+	require.True(t, streamed)
+	assert.False(t, res.changed)
+
+	after, err := os.Stat(filename)
+	require.NoError(t, err)
+
+	// The file was not replaced, and no temporary file is left behind.
+	assert.True(t, os.SameFile(before, after))
+	assert.Equal(t, before.ModTime(), after.ModTime())
+	assert.NoFileExists(t, filename+".tmp")
+
+	// A file that needs formatting still is.
+	require.NoError(t, os.WriteFile(filename, []byte("a=1\n"), 0644))
+
+	res, _, err = streamFile(filename, &stdout, &stderr, options{noSort: true, write: true})
+	require.NoError(t, err)
+	assert.True(t, res.changed)
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "a = 1\n\n", string(data))
+}
+
+func TestStreamChanged(t *testing.T) {
+	tests := []struct {
+		input   string
+		changed bool
+	}{
+		{"a = 1\n\n", false},
+		{"a = 1\n", true},
+		{"a = 1\n\n\n", true},
+	}
+
+	for _, tt := range tests {
+		filename := filepath.Join(t.TempDir(), "settings.conf")
+		require.NoError(t, os.WriteFile(filename, []byte(tt.input), 0644))
+
+		var stdout, stderr bytes.Buffer
+
+		res, streamed, err := streamFile(filename, &stdout, &stderr, options{noSort: true})
+		require.NoError(t, err)
+		require.True(t, streamed)
+		assert.Equal(t, tt.changed, res.changed, "%q", tt.input)
+	}
+}
+
+func TestStreamOutput(t *testing.T) {
+	dir := t.TempDir()
+
+	filename := filepath.Join(dir, "settings.conf")
+	require.NoError(t, os.WriteFile(filename, []byte("b=2\na=1\n"), 0644))
+
+	out := filepath.Join(dir, "out.conf")
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-n", "-o", out, filename}, strings.NewReader(""), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Empty(t, stdout.String())
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "b = 2\n\na = 1\n\n", string(data))
+
+	data, err = os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "b=2\na=1\n", string(data))
+}
+
+func TestCanStream(t *testing.T) {
+	assert.False(t, canStream(options{}))
+	assert.False(t, canStream(options{noSort: true, diff: true}))
+	assert.False(t, canStream(options{noSort: true, lintTypos: true}))
+	assert.False(t, canStream(options{noSort: true, include: true}))
+	assert.False(t, canStream(options{noSort: true, format: format.FormatOptions{AlignAll: true}}))
+	assert.True(t, canStream(options{noSort: true, write: true}))
+	assert.True(t, canStream(options{noSort: true, output: "out.conf", stripComments: true}))
+	assert.True(t, canStream(options{noSort: true, format: format.FormatOptions{AlignAll: true, Width: 20}}))
+}
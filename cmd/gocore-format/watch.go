@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// pollInterval is how often poll looks at a watched file.
+	pollInterval = 500 * time.Millisecond
+
+	// debounceDelay is how long a watched file must stay unchanged before it
+	// is formatted, so that an editor writing it in several steps triggers a
+	// single format.
+	debounceDelay = 200 * time.Millisecond
+)
+
+// changeSource returns a channel that receives a value whenever filename
+// changes, until ctx is done.
+type changeSource func(ctx context.Context, filename string) <-chan struct{}
+
+// poll is a changeSource that compares the modification time and size of a
+// file every pollInterval. A file that is briefly missing, as while an
+// editor replaces it, is not reported until it is back.
+func poll(ctx context.Context, filename string) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		last, _ := os.Stat(filename)
+
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(filename)
+			if err != nil {
+				continue
+			}
+
+			if last == nil || !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size() {
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+
+			last = info
+		}
+	}()
+
+	return changes
+}
+
+// debounce calls fn once no change has arrived for delay, until ctx is done
+// or changes is closed.
+func debounce(ctx context.Context, changes <-chan struct{}, delay time.Duration, fn func()) {
+	timer := time.NewTimer(delay)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case _, ok := <-changes:
+			if !ok {
+				timer.Stop()
+				return
+			}
+
+			timer.Reset(delay)
+		case <-timer.C:
+			fn()
+		}
+	}
+}
+
+// watch formats each file in place, then again whenever source reports a
+// change, printing a timestamped line for every file it rewrites. It
+// returns when ctx is done.
+func watch(ctx context.Context, filenames []string, source changeSource, stdout, stderr io.Writer, opts options) error {
+	if len(filenames) == 0 {
+		return errors.New("cannot use -watch when reading from stdin")
+	}
+
+	if opts.list || opts.diff || opts.merge || opts.stat || opts.listKeys || opts.json || opts.toml {
+		return errors.New("cannot use -watch with -l, -d, -merge, -stat, -list-keys, -json or -toml")
+	}
+
+	warnings, info := stderr, stdout
+	if opts.quiet {
+		warnings, info = io.Discard, io.Discard
+	}
+
+	var mu sync.Mutex
+
+	reformat := func(filename string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		changed, err := watchFormat(filename, warnings, opts)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s %s: %v\n", time.Now().Format(time.TimeOnly), filename, err)
+		} else if changed {
+			fmt.Fprintf(info, "%s formatted %s\n", time.Now().Format(time.TimeOnly), filename)
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	for _, filename := range filenames {
+		reformat(filename)
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			debounce(ctx, source(ctx, filename), debounceDelay, func() { reformat(filename) })
+		}()
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+// watchFormat rewrites filename if its formatting differs and reports
+// whether it did. It leaves a file that is already formatted untouched, so
+// that the write it makes itself does not trigger another round.
+func watchFormat(filename string, stderr io.Writer, opts options) (bool, error) {
+	check := opts
+	check.list = true
+
+	res, err := formatFile(filename, io.Discard, stderr, check)
+	if err != nil || !res.changed {
+		return false, err
+	}
+
+	// The check has already reported any diagnostics.
+	opts.write = true
+
+	_, err = formatFile(filename, io.Discard, io.Discard, opts)
+
+	return err == nil, err
+}
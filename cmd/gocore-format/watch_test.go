@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebounce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan struct{})
+	calls := make(chan struct{}, 10)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		debounce(ctx, changes, 50*time.Millisecond, func() { calls <- struct{}{} })
+	}()
+
+	// A burst of changes is formatted once, after it settles.
+	for i := 0; i < 5; i++ {
+		changes <- struct{}{}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	assert.Empty(t, calls)
+
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Fatal("debounce never called fn")
+	}
+
+	changes <- struct{}{}
+
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Fatal("debounce did not call fn for a later change")
+	}
+
+	cancel()
+	<-done
+
+	assert.Empty(t, calls)
+}
+
+// syncBuffer is a bytes.Buffer that can be written from several goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestWatch(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "settings.conf")
+	require.NoError(t, os.WriteFile(filename, []byte("b=1\na=2\n"), 0644))
+
+	changes := make(chan struct{})
+	source := func(ctx context.Context, name string) <-chan struct{} {
+		assert.Equal(t, filename, name)
+		return changes
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var stdout, stderr syncBuffer
+
+	done := make(chan error)
+
+	go func() {
+		done <- watch(ctx, []string{filename}, source, &stdout, &stderr, options{})
+	}()
+
+	// The file is formatted straight away.
+	require.Eventually(t, func() bool {
+		data, _ := os.ReadFile(filename)
+		return string(data) == "a = 2\n\nb = 1\n\n"
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, os.WriteFile(filename, []byte("c=3\n"), 0644))
+	changes <- struct{}{}
+
+	require.Eventually(t, func() bool {
+		data, _ := os.ReadFile(filename)
+		return string(data) == "c = 3\n\n"
+	}, time.Second, 10*time.Millisecond)
+
+	// The rewrite shows up as another change but leaves the file alone.
+	changes <- struct{}{}
+	time.Sleep(2 * debounceDelay)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	assert.Empty(t, stderr.String())
+	assert.Regexp(t, `^\d\d:\d\d:\d\d formatted .*settings.conf\n\d\d:\d\d:\d\d formatted .*settings.conf\n$`, stdout.String())
+}
+
+func TestWatchStdin(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-watch"}, bytes.NewReader(nil), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "cannot use -watch when reading from stdin\n", stderr.String())
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// writeFile replaces filename with the output of write by writing a
+// temporary file next to it and renaming it into place. The temporary file is
+// given the original file's permissions and, where the platform allows, its
+// owner and group. It is removed if anything fails before the rename.
+func writeFile(filename string, write func(io.Writer) error) (err error) {
+	tmp := filename + ".tmp"
+
+	info, statErr := os.Stat(filename)
+
+	perm := os.FileMode(0666)
+	if statErr == nil {
+		perm = info.Mode().Perm()
+	}
+
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("error creating output file: %w", err)
+	}
+	defer func() {
+		out.Close()
+
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+
+	if statErr == nil {
+		// OpenFile applies the umask, so set the mode explicitly.
+		if err := out.Chmod(perm); err != nil {
+			return fmt.Errorf("error setting file mode: %w", err)
+		}
+
+		// Changing ownership needs privileges we may not have; keeping the
+		// current owner is the best we can do in that case.
+		_ = chown(out, info)
+	}
+
+	if err := write(out); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error writing file: %w", err)
+	}
+
+	if err := os.Rename(tmp, filename); err != nil {
+		return fmt.Errorf("error renaming file: %w", err)
+	}
+
+	return nil
+}
+
+// backupFile saves src, the original contents of filename, as filename with
+// suffix appended, giving it the original file's permissions.
+func backupFile(filename, suffix string, src []byte) error {
+	backup := filename + suffix
+
+	perm := os.FileMode(0666)
+	if info, err := os.Stat(filename); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	if err := os.WriteFile(backup, src, perm); err != nil {
+		return fmt.Errorf("error writing backup: %w", err)
+	}
+
+	if err := os.Chmod(backup, perm); err != nil {
+		return fmt.Errorf("error writing backup: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFilePreservesMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported on windows")
+	}
+
+	for _, mode := range []os.FileMode{0600, 0640, 0751} {
+		filename := filepath.Join(t.TempDir(), "settings.conf")
+
+		require.NoError(t, os.WriteFile(filename, []byte("b=1\na=2\n"), 0644))
+		require.NoError(t, os.Chmod(filename, mode))
+
+		require.NoError(t, writeFile(filename, func(w io.Writer) error {
+			_, err := io.WriteString(w, "a = 2\n\nb = 1\n\n")
+			return err
+		}))
+
+		info, err := os.Stat(filename)
+		require.NoError(t, err)
+
+		assert.Equal(t, mode, info.Mode().Perm())
+
+		data, err := os.ReadFile(filename)
+		require.NoError(t, err)
+
+		assert.Equal(t, "a = 2\n\nb = 1\n\n", string(data))
+	}
+}
+
+func TestWriteFileRemovesTempOnWriteError(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "settings.conf")
+
+	require.NoError(t, os.WriteFile(filename, []byte("a=1\n"), 0644))
+
+	err := writeFile(filename, func(w io.Writer) error {
+		if _, err := io.WriteString(w, "a = "); err != nil {
+			return err
+		}
+
+		return errors.New("disk full")
+	})
+	assert.EqualError(t, err, "error writing file: disk full")
+
+	assert.NoFileExists(t, filename+".tmp")
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+
+	assert.Equal(t, "a=1\n", string(data))
+}
+
+func TestWriteFileRemovesTempOnRenameError(t *testing.T) {
+	// Renaming a file over a non-empty directory fails.
+	dir := filepath.Join(t.TempDir(), "settings.conf")
+
+	require.NoError(t, os.Mkdir(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "keep"), nil, 0644))
+
+	err := writeFile(dir, func(w io.Writer) error {
+		_, err := io.WriteString(w, "a = 1\n")
+		return err
+	})
+	assert.ErrorContains(t, err, "error renaming file")
+
+	assert.NoFileExists(t, dir+".tmp")
+}
+
+func TestBackup(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "settings.conf")
+
+	original := []byte("b=1\r\na=2 # keep\n")
+	require.NoError(t, os.WriteFile(filename, original, 0640))
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-w", "-backup", filename}, strings.NewReader(""), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+
+	backup, err := os.ReadFile(filename + ".bak")
+	require.NoError(t, err)
+	assert.Equal(t, original, backup)
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "a = 2 # keep\n\nb = 1\n\n", string(data))
+
+	// A file that is already formatted is neither backed up nor rewritten,
+	// so the backup still holds the original.
+	code = execute([]string{"-w", "-backup", filename}, strings.NewReader(""), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+
+	backup, err = os.ReadFile(filename + ".bak")
+	require.NoError(t, err)
+	assert.Equal(t, original, backup)
+
+	require.NoError(t, os.WriteFile(filename, []byte("c=3\n"), 0640))
+
+	code = execute([]string{"-w", "-backup", "-backup-suffix", ".orig", filename}, strings.NewReader(""), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+
+	backup, err = os.ReadFile(filename + ".orig")
+	require.NoError(t, err)
+	assert.Equal(t, "c=3\n", string(backup))
+}
+
+func TestOutputFile(t *testing.T) {
+	dir := t.TempDir()
+
+	in := filepath.Join(dir, "in.conf")
+	out := filepath.Join(dir, "out.conf")
+
+	original := []byte("b=1\na=2\n")
+	require.NoError(t, os.WriteFile(in, original, 0644))
+
+	var stdout, stderr bytes.Buffer
+
+	code := execute([]string{"-o", out, in}, strings.NewReader(""), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Empty(t, stdout.String())
+
+	data, err := os.ReadFile(in)
+	require.NoError(t, err)
+	assert.Equal(t, original, data)
+
+	data, err = os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "a = 2\n\nb = 1\n\n", string(data))
+
+	// A failed run leaves the earlier output alone.
+	code = execute([]string{"-o", out, filepath.Join(dir, "missing.conf")}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+
+	data, err = os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "a = 2\n\nb = 1\n\n", string(data))
+
+	stderr.Reset()
+
+	code = execute([]string{"-o", out, "-w", in}, strings.NewReader(""), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "cannot use -o with -w, -l, -d, -watch or -profile\n", stderr.String())
+}
@@ -0,0 +1,211 @@
+package format
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ChangeKind says how a variant differs between two sets of settings.
+type ChangeKind int
+
+const (
+	// Added is a variant that only the new settings have.
+	Added ChangeKind = iota
+
+	// Removed is a variant that only the old settings have.
+	Removed
+
+	// Modified is a variant whose value changed.
+	Modified
+
+	// CommentChanged is a variant whose inline comment changed.
+	CommentChanged
+
+	// CommentedOut is a live variant that the new settings comment out.
+	CommentedOut
+
+	// Uncommented is a commented-out variant that is live in the new
+	// settings.
+	Uncommented
+)
+
+var changeKindNames = map[ChangeKind]string{
+	Added:          "added",
+	Removed:        "removed",
+	Modified:       "modified",
+	CommentChanged: "comment changed",
+	CommentedOut:   "commented out",
+	Uncommented:    "uncommented",
+}
+
+func (k ChangeKind) String() string {
+	if name, ok := changeKindNames[k]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("ChangeKind(%d)", int(k))
+}
+
+// Change is one difference found by Diff. Old is the zero Variant for an
+// added variant and New for a removed one.
+type Change struct {
+	Kind ChangeKind
+	Key  string
+	Old  Variant
+	New  Variant
+}
+
+// Diff compares two sets of settings, such as two versions of a file, and
+// returns how the variants of before differ in after, ordered by key.
+// Variants are matched by full key: an identical variant is unchanged, a
+// variant commented out or uncommented with its value kept is a toggle, the
+// remaining live or commented ones are paired in order as edits, and only
+// then is any live variant left paired with a commented-out one as a
+// toggle. An edit of both the value and the inline comment gives a Modified
+// and a CommentChanged change, and a toggle gives either or both as well if
+// it comes with such an edit. Comment blocks and the order of variants are
+// not compared.
+func Diff(before, after []*Setting) []Change {
+	oldByKey, keys := variantsByKey(before, nil)
+	newByKey, keys := variantsByKey(after, keys)
+
+	var changes []Change
+
+	for _, key := range keys {
+		changes = append(changes, diffKey(key, oldByKey[key], newByKey[key])...)
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+
+	return changes
+}
+
+// variantsByKey groups the variants of settings by full key, appending keys
+// not seen before to keys.
+func variantsByKey(settings []*Setting, keys []string) (map[string][]Variant, []string) {
+	byKey := make(map[string][]Variant)
+
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		seen[key] = true
+	}
+
+	for _, setting := range settings {
+		for _, variant := range setting.Variants {
+			byKey[variant.Key] = append(byKey[variant.Key], variant)
+
+			if !seen[variant.Key] {
+				seen[variant.Key] = true
+				keys = append(keys, variant.Key)
+			}
+		}
+	}
+
+	return byKey, keys
+}
+
+// diffKey compares the variants of one full key before and after.
+func diffKey(key string, before, after []Variant) []Change {
+	var changes []Change
+
+	oldLive, oldCommented := splitCommented(before)
+	newLive, newCommented := splitCommented(after)
+
+	sameValue := func(a, b Variant) bool { return a.Value == b.Value }
+	identical := func(a, b Variant) bool { return a.Value == b.Value && a.Comment == b.Comment }
+	always := func(a, b Variant) bool { return true }
+
+	modify := func(o, n Variant) {
+		if o.Value != n.Value {
+			changes = append(changes, Change{Kind: Modified, Key: key, Old: o, New: n})
+		}
+
+		if o.Comment != n.Comment {
+			changes = append(changes, Change{Kind: CommentChanged, Key: key, Old: o, New: n})
+		}
+	}
+
+	// toggle records a variant that was commented out or uncommented, and
+	// any change to its value or inline comment.
+	toggle := func(kind ChangeKind) func(o, n Variant) {
+		return func(o, n Variant) {
+			changes = append(changes, Change{Kind: kind, Key: key, Old: o, New: n})
+			modify(o, n)
+		}
+	}
+
+	unchanged := func(o, n Variant) {}
+
+	// Most specific matches first: identical variants, then toggles that
+	// kept the value, then edits in place, then any remaining toggle.
+	oldLive, newLive = match(oldLive, newLive, identical, unchanged)
+	oldCommented, newCommented = match(oldCommented, newCommented, identical, unchanged)
+	oldLive, newCommented = match(oldLive, newCommented, sameValue, toggle(CommentedOut))
+	oldCommented, newLive = match(oldCommented, newLive, sameValue, toggle(Uncommented))
+	oldLive, newLive = match(oldLive, newLive, always, modify)
+	oldCommented, newCommented = match(oldCommented, newCommented, always, modify)
+	oldLive, newCommented = match(oldLive, newCommented, always, toggle(CommentedOut))
+	oldCommented, newLive = match(oldCommented, newLive, always, toggle(Uncommented))
+
+	for _, v := range append(oldLive, oldCommented...) {
+		changes = append(changes, Change{Kind: Removed, Key: key, Old: v})
+	}
+
+	for _, v := range append(newLive, newCommented...) {
+		changes = append(changes, Change{Kind: Added, Key: key, New: v})
+	}
+
+	return changes
+}
+
+// splitCommented returns the live and the commented-out variants of
+// variants, each in order.
+func splitCommented(variants []Variant) (live, commented []Variant) {
+	for _, v := range variants {
+		if v.Commented {
+			commented = append(commented, v)
+		} else {
+			live = append(live, v)
+		}
+	}
+
+	return live, commented
+}
+
+// match pairs each variant of before with the first unpaired variant of
+// after for which eq holds, calling found for each pair, and returns the
+// unpaired variants of each in order.
+func match(before, after []Variant, eq func(o, n Variant) bool, found func(o, n Variant)) ([]Variant, []Variant) {
+	used := make([]bool, len(after))
+
+	var oldRest []Variant
+
+	for _, o := range before {
+		matched := false
+
+		for i, n := range after {
+			if !used[i] && eq(o, n) {
+				used[i], matched = true, true
+				found(o, n)
+
+				break
+			}
+		}
+
+		if !matched {
+			oldRest = append(oldRest, o)
+		}
+	}
+
+	var newRest []Variant
+
+	for i, n := range after {
+		if !used[i] {
+			newRest = append(newRest, n)
+		}
+	}
+
+	return oldRest, newRest
+}
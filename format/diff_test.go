@@ -0,0 +1,114 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	parse := func(input string) []*Setting {
+		settings, err := Parse(strings.NewReader(input))
+		require.NoError(t, err)
+
+		return settings
+	}
+
+	before := parse(`db.host = a
+db.port = 1 # default
+debug = false
+# debug.dev = true
+gone = 1
+same = 1
+`)
+
+	after := parse(`db.host = b
+db.port = 1 # standard
+# debug = false
+debug = true
+debug.dev = true
+same = 1
+fresh = 2
+`)
+
+	type change struct {
+		kind          ChangeKind
+		key           string
+		before, after string
+	}
+
+	var got []change
+	for _, c := range Diff(before, after) {
+		got = append(got, change{c.Kind, c.Key, c.Old.Value, c.New.Value})
+	}
+
+	assert.Equal(t, []change{
+		{Modified, "db.host", "a", "b"},
+		{CommentChanged, "db.port", "1", "1"},
+		{CommentedOut, "debug", "false", "false"},
+		{Added, "debug", "", "true"},
+		{Uncommented, "debug.dev", "true", "true"},
+		{Added, "fresh", "", "2"},
+		{Removed, "gone", "1", ""},
+	}, got)
+
+	assert.Empty(t, Diff(before, before))
+}
+
+func TestDiffToggleWithNewValue(t *testing.T) {
+	before := []*Setting{{Key: "x", Variants: []Variant{{Key: "x", Value: "1"}}}}
+	after := []*Setting{{Key: "x", Variants: []Variant{{Key: "x", Value: "2", Commented: true}}}}
+
+	changes := Diff(before, after)
+	require.Len(t, changes, 2)
+	assert.Equal(t, CommentedOut, changes[0].Kind)
+	assert.Equal(t, "commented out", changes[0].Kind.String())
+	assert.Equal(t, "2", changes[0].New.Value)
+
+	// The value change is not lost to the toggle.
+	assert.Equal(t, Change{Kind: Modified, Key: "x", Old: before[0].Variants[0], New: after[0].Variants[0]}, changes[1])
+
+	changes = Diff(after, before)
+	require.Len(t, changes, 2)
+	assert.Equal(t, Uncommented, changes[0].Kind)
+	assert.Equal(t, Modified, changes[1].Kind)
+	assert.Equal(t, "1", changes[1].New.Value)
+}
+
+func TestDiffToggleWithNewComment(t *testing.T) {
+	tests := []struct {
+		name   string
+		before Variant
+		after  Variant
+		kinds  []ChangeKind
+	}{
+		{
+			name:   "same value",
+			before: Variant{Key: "x", Value: "1", Comment: "old"},
+			after:  Variant{Key: "x", Value: "1", Comment: "new", Commented: true},
+			kinds:  []ChangeKind{CommentedOut, CommentChanged},
+		},
+		{
+			name:   "new value",
+			before: Variant{Key: "x", Value: "1", Comment: "old", Commented: true},
+			after:  Variant{Key: "x", Value: "2", Comment: "new"},
+			kinds:  []ChangeKind{Uncommented, Modified, CommentChanged},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := []*Setting{{Key: "x", Variants: []Variant{tt.before}}}
+			after := []*Setting{{Key: "x", Variants: []Variant{tt.after}}}
+
+			var kinds []ChangeKind
+			for _, c := range Diff(before, after) {
+				kinds = append(kinds, c.Kind)
+			}
+
+			assert.Equal(t, tt.kinds, kinds)
+		})
+	}
+}
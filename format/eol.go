@@ -0,0 +1,27 @@
+package format
+
+import (
+	"bytes"
+	"io"
+)
+
+// DetectCRLF reports whether most of the line endings in src are CRLF.
+func DetectCRLF(src []byte) bool {
+	lines := bytes.Count(src, []byte("\n"))
+	crlf := bytes.Count(src, []byte("\r\n"))
+
+	return crlf > lines-crlf
+}
+
+// crlfWriter writes to w with every "\n" replaced by "\r\n".
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (c crlfWriter) Write(p []byte) (int, error) {
+	if _, err := c.w.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
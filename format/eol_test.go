@@ -0,0 +1,37 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectCRLF(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected bool
+	}{
+		{"", false},
+		{"a = 1\nb = 2\n", false},
+		{"a = 1\r\nb = 2\r\n", true},
+		{"a = 1\r\nb = 2\r\nc = 3\n", true},
+		{"a = 1\r\nb = 2\nc = 3\n", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, DetectCRLF([]byte(tt.src)), "%q", tt.src)
+	}
+}
+
+func TestFormatCRLF(t *testing.T) {
+	settings, err := Parse(strings.NewReader("# Section\na = x|y # note\na.dev = 2\n\na.prod = 3\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, FormatWithOptions(buf, settings, FormatOptions{CRLF: true, WrapWidth: 10, PreserveBlanks: true}))
+
+	assert.Equal(t, "# Section\r\na      = x | \\\r\n         y # note\r\na.dev  = 2\r\n\r\na.prod = 3\r\n\r\n", buf.String())
+}
@@ -0,0 +1,1361 @@
+package format
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Setting is a root key together with all of its variants and the comment
+// block that preceded it. A Setting with an Include holds, in place of a key
+// and variants, an include directive that was read without following it; it
+// is written back as read, and sorting keeps it where it was.
+type Setting struct {
+	Key      string    `json:"key"`
+	Comments string    `json:"comments,omitempty"`
+	Section  string    `json:"section,omitempty"`
+	Include  string    `json:"include,omitempty"`
+	Variants []Variant `json:"variants,omitempty"`
+}
+
+// Variant is a single key=value line, optionally commented out. Value is
+// raw: it is trimmed and its continuation lines are joined, but quotes and
+// escapes such as \# and \" are kept, so that a value read from a\#b is
+// a\#b and is written back as read.
+type Variant struct {
+	Commented bool   `json:"commented"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Comment   string `json:"comment,omitempty"` // The comment after the key=value pair
+	Line      int    `json:"-"`                 // The line number the variant was read from, if known
+
+	// BlankBefore records that a blank line separated this variant from
+	// the previous variant of the same setting.
+	BlankBefore bool `json:"blankBefore,omitempty"`
+
+	// LeadingComment is a comment block read between this variant and the
+	// previous variant of the same setting, written just before it.
+	LeadingComment string `json:"leadingComment,omitempty"`
+}
+
+// ParseOptions controls how settings are read.
+type ParseOptions struct {
+	// Warn, if set, is called for each line that is neither a valid
+	// setting nor a comment, with SeverityInfo. Such lines are kept as
+	// comments.
+	Warn func(Diagnostic)
+
+	// CaseInsensitiveGroup groups variants whose root keys differ only by
+	// case into one setting, labelled with the first casing seen. Each
+	// variant keeps its own casing.
+	CaseInsensitiveGroup bool
+
+	// Includes enables include directives. A line of the form
+	// "#include path" or "@include path" is replaced by the settings read
+	// from that file, which join the same root-key grouping. A relative
+	// path is resolved against the directory of the including file.
+	// Variants from an included file take the line number of the
+	// outermost include directive. Without Includes such a line is kept
+	// in a Setting of its own.
+	Includes bool
+
+	// Filename names the input, for resolving relative includes and
+	// detecting include cycles. It may be empty, in which case relative
+	// includes are resolved against the current directory.
+	Filename string
+
+	// CommentChar is the character that starts a comment or comments out a
+	// variant. It defaults to DefaultCommentChar.
+	CommentChar byte
+
+	// Sections treats a comment of the form "# [Name]" as a section marker
+	// rather than a comment. Each setting first seen after a marker gets
+	// its name as Section.
+	Sections bool
+
+	// Separator is the character between a key and its value. It defaults
+	// to '='.
+	Separator byte
+
+	// RawValues reads a value wrapped in backticks as is: a comment
+	// character or backslash inside the backticks is part of the value.
+	RawValues bool
+
+	// DedupeComments leaves out of the comment block before a later
+	// occurrence of a root key the lines that the setting's Comments
+	// already hold. Either way the block is appended to Comments as a new
+	// paragraph.
+	DedupeComments bool
+}
+
+// DefaultCommentChar is the comment character used when none is given.
+const DefaultCommentChar = '#'
+
+// commentChar returns c, or DefaultCommentChar if c is unset.
+func commentChar(c byte) byte {
+	if c == 0 {
+		return DefaultCommentChar
+	}
+
+	return c
+}
+
+// Parse reads settings from r, grouping variants by their root key. The
+// settings are returned in the order their root key first appeared.
+func Parse(r io.Reader) ([]*Setting, error) {
+	return ParseWithOptions(r, ParseOptions{})
+}
+
+// MaxIncludeDepth limits how deeply include directives may nest.
+const MaxIncludeDepth = 10
+
+// MaxLineSize is the longest physical input line, in bytes, that can be read.
+const MaxLineSize = 16 << 20
+
+// ParseWithOptions reads settings from r as controlled by opts.
+func ParseWithOptions(r io.Reader, opts ParseOptions) ([]*Setting, error) {
+	p, err := parseAll(r, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.order, nil
+}
+
+// ParseWithDiagnostics reads settings from r as ParseWithOptions does and
+// also returns, in line order, the problems found: the lines that Warn is
+// called for, then those reported by Lint. Warn is still called if set.
+func ParseWithDiagnostics(r io.Reader, opts ParseOptions) ([]*Setting, []Diagnostic, error) {
+	var diagnostics []Diagnostic
+
+	warn := opts.Warn
+	opts.Warn = func(d Diagnostic) {
+		diagnostics = append(diagnostics, d)
+
+		if warn != nil {
+			warn(d)
+		}
+	}
+
+	settings, err := ParseWithOptions(r, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	diagnostics = append(diagnostics, Lint(settings)...)
+
+	sortDiagnostics(diagnostics)
+
+	return settings, diagnostics, nil
+}
+
+// parseAll reads settings from r and returns the parser holding them.
+func parseAll(r io.Reader, opts ParseOptions) (*parser, error) {
+	return parseWith(&parser{
+		opts:     opts,
+		settings: make(map[string]*Setting),
+		lines:    make(map[*Setting][]int),
+	}, r)
+}
+
+// parseWith reads settings from r into p and returns it.
+func parseWith(p *parser, r io.Reader) (*parser, error) {
+	opts := p.opts
+
+	if opts.Filename != "" {
+		if abs, err := filepath.Abs(opts.Filename); err == nil {
+			p.stack = append(p.stack, abs)
+		}
+	}
+
+	if err := p.parse(r, filepath.Dir(opts.Filename)); err != nil {
+		return nil, err
+	}
+
+	if p.pendingSectionComment != "" {
+		// A comment block with no setting after it is kept as a trailing
+		// setting without variants so that it is not lost on rewrite.
+		setting := &Setting{
+			Comments: p.pendingSectionComment,
+		}
+
+		p.order = append(p.order, setting)
+		p.lines[setting] = p.pendingLines
+	}
+
+	return p, nil
+}
+
+// parser holds the state shared by an input and the files it includes.
+type parser struct {
+	opts     ParseOptions
+	settings map[string]*Setting
+	order    []*Setting
+
+	pendingSectionComment string
+	blank                 bool
+
+	// paragraph records a blank line since the last comment line, so that
+	// comment paragraphs stay apart in pendingSectionComment.
+	paragraph bool
+
+	// section is the name of the last section marker seen.
+	section string
+
+	// last is the setting of the last variant read, so that a comment
+	// block before another of its variants stays with that variant.
+	last *Setting
+
+	// flush, if set, is given the settings read so far each time a new root
+	// key starts, as their variants are then complete. They are dropped from
+	// order and left in settings as nil so that a root key seen again can be
+	// told apart from a new one.
+	flush func(*Setting) error
+
+	// stack holds the absolute paths of the files being parsed, outermost
+	// first, to detect include cycles.
+	stack []string
+
+	// includeLine is the line of the outermost include directive while an
+	// included file is being parsed, or 0.
+	includeLine int
+
+	// lines holds the physical lines of the outermost input that each
+	// setting, with its comment block, was read from. pendingLines holds
+	// those of pendingSectionComment.
+	lines        map[*Setting][]int
+	pendingLines []int
+}
+
+// appendComments returns the comment block more added to comments as a new
+// paragraph, without the lines that comments already holds if dedupe is set.
+func appendComments(comments, more string, dedupe bool) string {
+	if !dedupe {
+		if comments == "" {
+			return more
+		}
+
+		return comments + "\n\n" + more
+	}
+
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(comments, "\n") {
+		seen[line] = true
+	}
+
+	var kept []string
+
+	for _, line := range strings.Split(more, "\n") {
+		if line == "" {
+			// Keep paragraph breaks, but not at the start or twice in a row.
+			if len(kept) > 0 && kept[len(kept)-1] != "" {
+				kept = append(kept, line)
+			}
+
+			continue
+		}
+
+		if !seen[line] {
+			seen[line] = true
+			kept = append(kept, line)
+		}
+	}
+
+	if len(kept) > 0 && kept[len(kept)-1] == "" {
+		kept = kept[:len(kept)-1]
+	}
+
+	switch {
+	case len(kept) == 0:
+		return comments
+	case comments == "":
+		return strings.Join(kept, "\n")
+	default:
+		return comments + "\n\n" + strings.Join(kept, "\n")
+	}
+}
+
+// own records that the physical lines from start to end of the outermost
+// input belong to setting, or to the pending comment block if setting is nil.
+func (p *parser) own(setting *Setting, start, end int) {
+	if p.includeLine != 0 {
+		return
+	}
+
+	for line := start; line <= end; line++ {
+		if setting == nil {
+			p.pendingLines = append(p.pendingLines, line)
+		} else {
+			p.lines[setting] = append(p.lines[setting], line)
+		}
+	}
+}
+
+func (p *parser) parse(r io.Reader, dir string) error {
+	c := commentChar(p.opts.CommentChar)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, MaxLineSize)
+
+	lines := &lineReader{scanner: scanner, commentChar: c}
+
+	for {
+		line, lineNumber, ok := lines.next()
+		if !ok {
+			break
+		}
+
+		if line == "" {
+			p.blank = true
+			p.paragraph = true
+			continue
+		}
+
+		if path, ok := includePath(line); ok {
+			if !p.opts.Includes {
+				if err := p.directive(line, lineNumber, lines.line); err != nil {
+					return err
+				}
+			} else if err := p.include(path, dir, lineNumber); err != nil {
+				return fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+
+			continue
+		}
+
+		item := processLine(line, c, p.opts.Separator, p.opts.RawValues)
+
+		if item == nil {
+			// This is an arbitrary comment line
+			if line[0] == c {
+				line = strings.TrimSpace(line[1:])
+			} else {
+				p.warn(lineNumber, fmt.Sprintf("%q is neither a setting nor a comment", line))
+			}
+
+			p.own(nil, lineNumber, lines.line)
+
+			if name, ok := sectionName(line); ok && p.opts.Sections {
+				p.section = name
+				continue
+			}
+
+			if line == "" {
+				// A bare comment marker separates paragraphs like a blank
+				// line, which is how it is written back.
+				p.paragraph = true
+				continue
+			}
+
+			switch {
+			case p.pendingSectionComment == "":
+				p.pendingSectionComment = line
+			case p.paragraph:
+				p.pendingSectionComment += "\n\n" + line
+			default:
+				p.pendingSectionComment += "\n" + line
+			}
+
+			p.paragraph = false
+		} else {
+			rootKey := strings.Split(item.Key, ".")[0]
+			if rootKey == "" {
+				return fmt.Errorf("line %d: empty key in %q", lineNumber, line)
+			}
+
+			if hasSpace(item.Key) {
+				return fmt.Errorf("line %d: key %q contains whitespace", lineNumber, item.Key)
+			}
+
+			groupKey := rootKey
+			if p.opts.CaseInsensitiveGroup {
+				groupKey = strings.ToLower(rootKey)
+			}
+
+			setting, found := p.settings[groupKey]
+			if found && setting == nil {
+				return fmt.Errorf("line %d: %w: %q", lineNumber, ErrNotContiguous, rootKey)
+			}
+
+			if !found {
+				if err := p.flushAll(); err != nil {
+					return err
+				}
+
+				setting = &Setting{
+					Key:      rootKey,
+					Comments: p.pendingSectionComment,
+					Section:  p.section,
+				}
+
+				p.pendingSectionComment = ""
+
+				p.order = append(p.order, setting)
+
+				p.lines[setting] = p.pendingLines
+				p.pendingLines = nil
+			} else if p.pendingSectionComment != "" {
+				if setting == p.last {
+					item.LeadingComment = p.pendingSectionComment
+				} else {
+					setting.Comments = appendComments(setting.Comments, p.pendingSectionComment, p.opts.DedupeComments)
+				}
+
+				p.pendingSectionComment = ""
+
+				p.lines[setting] = append(p.lines[setting], p.pendingLines...)
+				p.pendingLines = nil
+			}
+
+			p.last = setting
+
+			p.own(setting, lineNumber, lines.line)
+
+			item.Line = lineNumber
+			if p.includeLine != 0 {
+				item.Line = p.includeLine
+			}
+
+			item.BlankBefore = p.blank && len(setting.Variants) > 0
+
+			p.blank = false
+
+			setting.Variants = append(setting.Variants, *item)
+
+			p.settings[groupKey] = setting
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("line %d: longer than %d bytes", lines.line+1, MaxLineSize)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// directive adds the include directive line, read from the physical lines
+// start to end, as a setting of its own that takes the pending comment block.
+func (p *parser) directive(line string, start, end int) error {
+	if err := p.flushAll(); err != nil {
+		return err
+	}
+
+	setting := &Setting{
+		Comments: p.pendingSectionComment,
+		Section:  p.section,
+		Include:  line,
+	}
+
+	p.pendingSectionComment = ""
+
+	p.order = append(p.order, setting)
+
+	p.lines[setting] = p.pendingLines
+	p.pendingLines = nil
+
+	p.own(setting, start, end)
+
+	p.last = setting
+	p.blank = false
+
+	return nil
+}
+
+// flushAll passes every setting in order to flush, if it is set, and
+// forgets them.
+func (p *parser) flushAll() error {
+	if p.flush == nil {
+		return nil
+	}
+
+	for _, setting := range p.order {
+		if err := p.flush(setting); err != nil {
+			return err
+		}
+
+		if len(setting.Variants) > 0 {
+			groupKey := setting.Key
+			if p.opts.CaseInsensitiveGroup {
+				groupKey = strings.ToLower(groupKey)
+			}
+
+			// The key shares memory with its input line, which would
+			// otherwise be kept alive with it.
+			p.settings[strings.Clone(groupKey)] = nil
+		}
+
+		delete(p.lines, setting)
+	}
+
+	clear(p.order)
+	p.order = p.order[:0]
+
+	return nil
+}
+
+// warn reports a problem on line of the file being parsed. Problems in an
+// included file are reported on the line of the include directive, naming
+// the included file and its own line.
+func (p *parser) warn(line int, message string) {
+	if p.opts.Warn == nil {
+		return
+	}
+
+	if p.includeLine != 0 {
+		message = fmt.Sprintf("%s:%d: %s", p.stack[len(p.stack)-1], line, message)
+		line = p.includeLine
+	}
+
+	p.opts.Warn(Diagnostic{Line: line, Severity: SeverityInfo, Message: message})
+}
+
+// include parses the file at path, relative to dir, into the settings being
+// built.
+func (p *parser) include(path, dir string, line int) error {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	for i, visiting := range p.stack {
+		if visiting == abs {
+			return fmt.Errorf("include cycle: %s", strings.Join(append(p.stack[i:], abs), " -> "))
+		}
+	}
+
+	if len(p.stack) > MaxIncludeDepth {
+		return fmt.Errorf("includes nested more than %d deep", MaxIncludeDepth)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if p.includeLine == 0 {
+		p.includeLine = line
+		defer func() { p.includeLine = 0 }()
+	}
+
+	p.stack = append(p.stack, abs)
+	defer func() { p.stack = p.stack[:len(p.stack)-1] }()
+
+	if err := p.parse(f, filepath.Dir(path)); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+// sectionName returns the name in a section marker comment such as
+// "[Database]", given without its comment character.
+func sectionName(comment string) (string, bool) {
+	if len(comment) < 2 || comment[0] != '[' || comment[len(comment)-1] != ']' {
+		return "", false
+	}
+
+	name := strings.TrimSpace(comment[1 : len(comment)-1])
+
+	return name, name != ""
+}
+
+// includePath returns the path named by an include directive line.
+func includePath(line string) (string, bool) {
+	for _, directive := range []string{"#include", "@include"} {
+		rest, found := strings.CutPrefix(line, directive)
+		if !found || rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+			continue
+		}
+
+		path := strings.TrimSpace(rest)
+		if len(path) >= 2 && path[0] == '"' && path[len(path)-1] == '"' {
+			path = path[1 : len(path)-1]
+		}
+
+		return path, path != ""
+	}
+
+	return "", false
+}
+
+// byteOrderMark is the UTF-8 encoding of U+FEFF, which some editors write at
+// the start of a file.
+const byteOrderMark = "\ufeff"
+
+// lineReader yields trimmed logical lines from a scanner. Trimming also
+// drops the carriage return of a CRLF line ending, and a UTF-8 byte order
+// mark is dropped from the first line. A setting line ending in a backslash
+// is joined with the line that follows it, as in a shell script.
+type lineReader struct {
+	scanner     *bufio.Scanner
+	commentChar byte
+	line        int
+}
+
+// next returns the next logical line and the number of the physical line it
+// started on.
+func (lr *lineReader) next() (string, int, bool) {
+	var (
+		text    string
+		start   int
+		joining bool
+	)
+
+	for lr.scanner.Scan() {
+		lr.line++
+
+		line := lr.scanner.Text()
+		if lr.line == 1 {
+			line = strings.TrimPrefix(line, byteOrderMark)
+		}
+
+		line = strings.TrimSpace(line)
+
+		if joining {
+			line = strings.TrimSpace(text + line)
+		} else {
+			start = lr.line
+		}
+
+		if isContinued(line, lr.commentChar) {
+			text = line[:len(line)-1]
+			joining = true
+
+			continue
+		}
+
+		return line, start, true
+	}
+
+	if joining {
+		return strings.TrimSpace(text), start, true
+	}
+
+	return "", 0, false
+}
+
+// isContinued reports whether a setting line ends in an unescaped backslash.
+// Comment lines, starting with commentChar, are never continued.
+func isContinued(line string, commentChar byte) bool {
+	if line != "" && line[0] == commentChar {
+		return false
+	}
+
+	n := len(line) - len(strings.TrimRight(line, "\\"))
+
+	return n%2 == 1
+}
+
+// FormatOptions controls how settings are written.
+type FormatOptions struct {
+	// WrapWidth, when positive, wraps multi-value lists of live variants
+	// whose line would be longer than WrapWidth onto backslash continuation
+	// lines, breaking only between '|' separated values.
+	WrapWidth int
+
+	// AlignComments pads each variant of a setting so that its inline
+	// comment starts in the same column as the others in that setting.
+	AlignComments bool
+
+	// NoPipeNormalize writes values exactly as parsed instead of
+	// normalizing the spacing around '|' in multi-value lists. It also
+	// disables WrapWidth and SortValues.
+	NoPipeNormalize bool
+
+	// SortValues sorts the values of each multi-value list and removes
+	// repeats, for lists whose order does not matter. Separators inside
+	// quotes or brackets are not split on, as for pipe normalization.
+	SortValues bool
+
+	// PreserveBlanks keeps a single blank line between variants of a
+	// setting that were separated by blank lines in the input.
+	PreserveBlanks bool
+
+	// ExpandEnv replaces ${VAR} and $VAR references in values, including
+	// quoted ones, with the value of the environment variable. Keys and
+	// comments are never expanded. A reference to an unset variable is
+	// replaced with an empty string, or left as written if KeepUnsetEnv is
+	// set. A dollar sign escaped as \$ is not expanded.
+	ExpandEnv    bool
+	KeepUnsetEnv bool
+
+	// Indent indents each variant key by one space per level of nesting
+	// below its root key, so that c.dev.host is indented two spaces. For a
+	// commented variant the indent follows the comment marker.
+	Indent bool
+
+	// Width, when positive, pads every key to Width columns, counting the
+	// comment marker of a commented variant, so that '=' lines up across the
+	// whole document instead of per setting. A longer key is followed by a
+	// single space. AlignAll does the same using the longest key in the
+	// document and is ignored when Width is set.
+	Width    int
+	AlignAll bool
+
+	// NoAlign writes every variant as "key = value" with no padding, so that
+	// adding a longer key does not change the lines around it. It overrides
+	// Width and AlignAll.
+	NoAlign bool
+
+	// Separator is written between each key, padded as usual, and its
+	// value. It defaults to " = ". Parse with the matching
+	// ParseOptions.Separator to read the output back.
+	Separator string
+
+	// Stamp writes Stamp as a comment on the first line, followed by a
+	// blank line. Pass settings through StripStamp first to replace an
+	// existing stamp rather than add another.
+	Stamp bool
+
+	// RawValues writes a value wrapped in backticks exactly as it was
+	// read, with none of the changes made to other values. Parse with
+	// ParseOptions.RawValues so that the value is read whole.
+	RawValues bool
+
+	// TabWidth is the number of spaces that each tab in a value or comment
+	// is written as, so that the output only ever uses spaces. Zero means
+	// one space.
+	TabWidth int
+
+	// CommentChar is the character written before comments and
+	// commented-out variants. It defaults to DefaultCommentChar.
+	CommentChar byte
+
+	// ValueTransform, if set, is called with the full key and value of each
+	// live variant, after ExpandEnv, and its result is written in place of
+	// the value. Commented-out variants are only passed to it when
+	// TransformCommented is set. A nil ValueTransform leaves values as they
+	// are.
+	ValueTransform     func(key, value string) string
+	TransformCommented bool
+
+	// CRLF ends every line, including blank ones, with "\r\n" instead of
+	// "\n".
+	CRLF bool
+}
+
+// Format writes settings to w in canonical form. A variant with an empty
+// value is written as "key =", aligned with the others, with nothing after
+// the '=' but its inline comment, if any. An explicitly quoted empty value,
+// "", is kept as written. A setting with neither variants nor comments is
+// left out.
+func Format(w io.Writer, settings []*Setting) error {
+	return FormatWithOptions(w, settings, FormatOptions{})
+}
+
+// FormatWithOptions writes settings to w in canonical form as controlled by
+// opts.
+func FormatWithOptions(w io.Writer, settings []*Setting, opts FormatOptions) error {
+	if opts.CRLF {
+		w = crlfWriter{w}
+	}
+
+	writer := bufio.NewWriter(w)
+	defer writer.Flush()
+
+	marker := commentMarker(opts)
+	width := keyColumn(settings, opts)
+
+	if opts.Stamp {
+		if _, err := writer.WriteString(marker + Stamp + "\n\n"); err != nil {
+			return err
+		}
+	}
+
+	section := ""
+
+	for _, setting := range settings {
+		if err := writeSetting(writer, setting, width, &section, opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeSetting writes setting, with its keys padded to width, followed by a
+// blank line. section is the section of the settings written so far, and a
+// section marker is written first if setting starts another.
+func writeSetting(writer *bufio.Writer, setting *Setting, width int, section *string, opts FormatOptions) error {
+	if len(setting.Variants) == 0 && setting.Comments == "" && setting.Include == "" {
+		// There is nothing to write but the blank line after it.
+		return nil
+	}
+
+	marker := commentMarker(opts)
+
+	if setting.Section != *section && len(setting.Variants) > 0 {
+		*section = setting.Section
+
+		if *section != "" {
+			if _, err := writer.WriteString(marker + "[" + *section + "]\n\n"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if setting.Comments != "" {
+		if err := writeComments(writer, strings.ReplaceAll(setting.Comments, "\t", tabSpaces(opts)), marker); err != nil {
+			return err
+		}
+	}
+
+	if setting.Include != "" {
+		if _, err := writer.WriteString(setting.Include + "\n"); err != nil {
+			return err
+		}
+	}
+
+	for _, line := range variantLines(setting, width, marker, opts) {
+		if _, err := writer.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+
+	_, err := writer.WriteString("\n")
+
+	return err
+}
+
+// commentMarker returns the text written before comments and commented-out
+// variants.
+func commentMarker(opts FormatOptions) string {
+	return string(commentChar(opts.CommentChar)) + " "
+}
+
+// separator returns the text written between a key and its value.
+func separator(opts FormatOptions) string {
+	if opts.Separator == "" {
+		return " = "
+	}
+
+	return opts.Separator
+}
+
+// tabSpaces returns the spaces that a tab is written as.
+func tabSpaces(opts FormatOptions) string {
+	return strings.Repeat(" ", max(opts.TabWidth, 1))
+}
+
+// keyColumn returns the key width shared by every setting, or 0 if each
+// setting is aligned on its own.
+func keyColumn(settings []*Setting, opts FormatOptions) int {
+	width := opts.Width
+	if width <= 0 && opts.AlignAll {
+		for _, setting := range settings {
+			width = max(width, keyWidth(setting, opts))
+		}
+	}
+
+	return max(width, 0)
+}
+
+// variantLines returns the formatted text of each variant of setting, keys
+// padded to width or, if width is 0, to the longest key of the setting. The
+// text of a variant spans several output lines when its value is wrapped or
+// a blank line is kept before it.
+func variantLines(setting *Setting, width int, marker string, opts FormatOptions) []string {
+	maxKeyLength := width
+	if maxKeyLength <= 0 {
+		maxKeyLength = keyWidth(setting, opts)
+	}
+
+	tab := tabSpaces(opts)
+
+	lines := make([]string, 0, len(setting.Variants))
+	maxLineLength := 0
+
+	for _, variant := range setting.Variants {
+		prefix := ""
+
+		length := maxKeyLength
+
+		if variant.Commented {
+			prefix = marker
+			length -= len(prefix)
+		}
+
+		if opts.NoAlign {
+			// A negative width would pad on the left.
+			length = 0
+		}
+
+		value := variant.Value
+
+		raw := opts.RawValues && isRawValue(value)
+		if !raw {
+			value = formatValue(variant, tab, opts)
+		}
+
+		key := variant.Key
+		if opts.Indent {
+			key = strings.Repeat(" ", keyDepth(key)) + key
+		}
+
+		// An empty value would leave the padding and a space after '='.
+		line := strings.TrimRightFunc(fmt.Sprintf("%s%-*s%s%s", prefix, length, key, separator(opts), value), unicode.IsSpace)
+
+		if opts.WrapWidth > 0 && !variant.Commented && !opts.NoPipeNormalize && !raw {
+			line = wrapValue(line, len(line)-len(value), opts.WrapWidth)
+		}
+
+		lines = append(lines, line)
+
+		if l := lastLineLength(line); l > maxLineLength {
+			maxLineLength = l
+		}
+	}
+
+	for i, variant := range setting.Variants {
+		line := lines[i]
+
+		if variant.LeadingComment != "" {
+			line = leadingComment(strings.ReplaceAll(variant.LeadingComment, "\t", tab), marker) + line
+		}
+
+		if opts.PreserveBlanks && variant.BlankBefore && i > 0 {
+			line = "\n" + line
+		}
+
+		if comment := strings.ReplaceAll(variant.Comment, "\t", tab); comment != "" {
+			if opts.AlignComments {
+				line += strings.Repeat(" ", maxLineLength-lastLineLength(line))
+			}
+
+			if comment[0] == marker[0] {
+				line += " " + marker[:1] + comment
+			} else {
+				line += " " + marker + comment
+			}
+		} else if !variant.Commented && isContinued(line, marker[0]) {
+			// A value ending in an unescaped backslash, as read from
+			// "x\ #", would join the next line when read back; a bare
+			// comment marker ends the line as it did in the input.
+			line += " " + marker[:1]
+		}
+
+		lines[i] = line
+	}
+
+	return lines
+}
+
+// formatValue returns the value of variant as written, with the
+// transformations selected by opts applied.
+func formatValue(variant Variant, tab string, opts FormatOptions) string {
+	value := variant.Value
+	if opts.ExpandEnv {
+		value = expandEnv(value, opts.KeepUnsetEnv)
+	}
+
+	if opts.ValueTransform != nil && (!variant.Commented || opts.TransformCommented) {
+		value = opts.ValueTransform(variant.Key, value)
+	}
+
+	value = strings.ReplaceAll(value, "\t", tab)
+
+	if !opts.NoPipeNormalize {
+		value = cleanMultiValues(value)
+
+		if opts.SortValues {
+			value = sortMultiValues(value)
+		}
+	}
+
+	return value
+}
+
+// isRawValue reports whether value is wrapped in backticks.
+func isRawValue(value string) bool {
+	return len(value) >= 2 && value[0] == '`' && value[len(value)-1] == '`'
+}
+
+// keyWidth returns the width in characters of the longest key of setting as
+// written, including the comment marker of a commented variant.
+func keyWidth(setting *Setting, opts FormatOptions) int {
+	width := 0
+
+	for _, variant := range setting.Variants {
+		l := utf8.RuneCountInString(variant.Key)
+		if opts.Indent {
+			l += keyDepth(variant.Key)
+		}
+
+		if variant.Commented {
+			l += len(commentMarker(opts))
+		}
+
+		width = max(width, l)
+	}
+
+	return width
+}
+
+// leadingComment returns the lines of a variant's leading comment, each
+// prefixed by marker. A paragraph break is written as a bare marker so that
+// the block does not read as the end of the setting.
+func leadingComment(comment, marker string) string {
+	var b strings.Builder
+
+	for _, line := range strings.Split(comment, "\n") {
+		b.WriteString(strings.TrimRightFunc(marker+line, unicode.IsSpace) + "\n")
+	}
+
+	return b.String()
+}
+
+// writeComments writes a comment block with each line prefixed by marker.
+// Empty lines separate paragraphs and are written as blank lines.
+func writeComments(w *bufio.Writer, comments, marker string) error {
+	for _, line := range strings.Split(comments, "\n") {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if line != "" {
+			line = marker + line
+		}
+
+		if _, err := w.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keyDepth returns how many levels below its root key a key is nested.
+func keyDepth(key string) int {
+	return strings.Count(key, ".")
+}
+
+// lastLineLength returns the length of the last line in a possibly wrapped
+// line.
+func lastLineLength(line string) int {
+	return utf8.RuneCountInString(line[strings.LastIndexByte(line, '\n')+1:])
+}
+
+// wrapValue breaks a formatted line whose multi-value list starts at column
+// col onto continuation lines no longer than width where possible. Values
+// are never split, so a single long value may still exceed width.
+func wrapValue(line string, col int, width int) string {
+	if len(line) <= width {
+		return line
+	}
+
+	parts := splitMultiValues(line[col:])
+	if len(parts) < 2 {
+		return line
+	}
+
+	const separator = " | "
+	const continuation = " | \\"
+
+	var lines []string
+
+	current := strings.TrimSpace(parts[0])
+
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+
+		if col+len(current)+len(separator)+len(part)+len(continuation) > width {
+			lines = append(lines, current)
+			current = part
+		} else {
+			current += separator + part
+		}
+	}
+
+	lines = append(lines, current)
+
+	indent := strings.Repeat(" ", col)
+
+	var b strings.Builder
+
+	b.WriteString(line[:col])
+
+	for i, l := range lines {
+		if i > 0 {
+			b.WriteString("\n" + indent)
+		}
+
+		b.WriteString(l)
+
+		if i < len(lines)-1 {
+			b.WriteString(continuation)
+		}
+	}
+
+	return b.String()
+}
+
+// expandEnv replaces environment variable references in value.
+func expandEnv(value string, keepUnset bool) string {
+	var b strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+
+		if c == '\\' && i+1 < len(value) {
+			b.WriteByte(c)
+			b.WriteByte(value[i+1])
+			i++
+
+			continue
+		}
+
+		if c != '$' {
+			b.WriteByte(c)
+			continue
+		}
+
+		var name, ref string
+
+		if i+1 < len(value) && value[i+1] == '{' {
+			end := strings.IndexByte(value[i:], '}')
+			if end < 0 {
+				b.WriteByte(c)
+				continue
+			}
+
+			ref = value[i : i+end+1]
+			name = ref[2 : len(ref)-1]
+		} else {
+			j := i + 1
+			for j < len(value) && isEnvNameByte(value[j], j == i+1) {
+				j++
+			}
+
+			ref = value[i:j]
+			name = ref[1:]
+		}
+
+		if name == "" {
+			b.WriteString(ref)
+		} else if v, ok := os.LookupEnv(name); ok {
+			b.WriteString(v)
+		} else if keepUnset {
+			b.WriteString(ref)
+		}
+
+		i += len(ref) - 1
+	}
+
+	return b.String()
+}
+
+func isEnvNameByte(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+func processLine(line string, commentChar, separator byte, raw bool) *Variant {
+
+	setting := &Variant{}
+
+	if line[0] == commentChar {
+		setting.Commented = true
+		line = line[1:]
+	}
+
+	if separator == 0 {
+		separator = '='
+	}
+
+	parts := strings.SplitN(line, string(separator), 2)
+
+	if len(parts) == 1 || strings.IndexByte(parts[0], commentChar) >= 0 {
+		// Without a separator before the comment, as in "k # a = b", the
+		// line has no key=value pair; the comment is only ever split from
+		// what follows the separator.
+		return nil
+	}
+
+	setting.Key = cleanKey(parts[0])
+
+	if setting.Commented && (strings.Split(setting.Key, ".")[0] == "" || hasSpace(setting.Key)) {
+		// A commented line such as "# ======" or "# Note: a = b" is a plain
+		// comment, not a commented-out setting.
+		return nil
+	}
+
+	line = strings.TrimSpace(parts[1])
+
+	value, comment, found := splitComment(line, commentChar, raw)
+	setting.Value = strings.TrimSpace(value)
+
+	if found {
+		setting.Comment = inlineComment(comment, commentChar)
+	}
+
+	return setting
+}
+
+// splitComment splits s at the first commentChar that starts an inline
+// comment. A commentChar inside double quotes or escaped with a backslash is
+// part of the value; the quotes and the escape are kept in the value so that
+// it round-trips unchanged. With raw, so is everything in a backtick-wrapped
+// value at the start of s.
+func splitComment(s string, commentChar byte, raw bool) (value, comment string, found bool) {
+	inQuotes := false
+
+	if raw && len(s) > 0 && s[0] == '`' {
+		if end := strings.IndexByte(s[1:], '`'); end >= 0 {
+			// Skip the raw value, so that only what follows it can be
+			// a comment.
+			value, comment, found = splitComment(s[end+2:], commentChar, false)
+			return s[:end+2] + value, comment, found
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuotes = !inQuotes
+		case commentChar:
+			if !inQuotes {
+				return s[:i], s[i+1:], true
+			}
+		}
+	}
+
+	return s, "", false
+}
+
+// inlineComment returns the text of an inline comment that followed the
+// first commentChar in canonical form. Any further comment characters at its
+// start, as in "## note", stay with the marker, and the text is separated
+// from them by a single space. Comment characters inside the text, as in
+// "see #123", are kept.
+func inlineComment(comment string, commentChar byte) string {
+	comment = strings.TrimSpace(comment)
+
+	run := len(comment) - len(strings.TrimLeft(comment, string(commentChar)))
+	if run == 0 {
+		return comment
+	}
+
+	text := strings.TrimSpace(comment[run:])
+	if text == "" {
+		return comment[:run]
+	}
+
+	return comment[:run] + " " + text
+}
+
+// cleanKey trims the whitespace around each dot-separated segment of key, so
+// that "db . host" becomes "db.host". Whitespace inside a segment, as in
+// "db host.port", is kept; such a key is rejected on a setting line and makes
+// a commented line a plain comment. Empty segments are kept too, so that
+// "foo. .bar" and "foo..bar" both become "foo..bar"; EmptySegments reports
+// them.
+func cleanKey(key string) string {
+	parts := strings.Split(strings.TrimSpace(key), ".")
+
+	for i := 0; i < len(parts); i++ {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// hasSpace reports whether key contains whitespace.
+func hasSpace(key string) bool {
+	return strings.ContainsFunc(key, unicode.IsSpace)
+}
+
+// Values returns the elements of the value read as a gocore list: split on
+// '|' as by splitMultiValues, so that quoted text and regular expression
+// groups stay whole, with the spaces around each element trimmed. A value
+// that is not a list is a single element.
+func (v Variant) Values() []string {
+	return listValues(v.Value)
+}
+
+// SetValues sets the value to the list of values, written as Format writes
+// lists.
+func (v *Variant) SetValues(values []string) {
+	v.Value = joinValues(values)
+}
+
+// listValues returns the trimmed elements of the list value.
+func listValues(value string) []string {
+	parts := splitMultiValues(value)
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+	}
+
+	return parts
+}
+
+// joinValues writes values as a list.
+func joinValues(values []string) string {
+	return strings.Join(values, " | ")
+}
+
+func cleanMultiValues(value string) string {
+	return joinValues(listValues(value))
+}
+
+// sortMultiValues sorts the values of a multi-value list and drops repeats.
+// A value that is not a list is returned unchanged.
+func sortMultiValues(value string) string {
+	values := listValues(value)
+	if len(values) < 2 {
+		return value
+	}
+
+	slices.Sort(values)
+
+	return joinValues(slices.Compact(values))
+}
+
+// splitMultiValues splits value on '|'. Text inside double quotes is taken
+// literally and a '|' nested in parentheses, brackets or braces, such as the
+// alternation in ^(foo|bar)$, does not separate values.
+func splitMultiValues(value string) []string {
+	var (
+		parts    []string
+		inQuotes bool
+		depth    int
+		start    int
+	)
+
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuotes = !inQuotes
+		case '(', '[', '{':
+			if !inQuotes {
+				depth++
+			}
+		case ')', ']', '}':
+			if !inQuotes && depth > 0 {
+				depth--
+			}
+		case '|':
+			if !inQuotes && depth == 0 {
+				parts = append(parts, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, value[start:])
+}
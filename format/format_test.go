@@ -0,0 +1,1379 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanMultiValues(t *testing.T) {
+	v := "1|2|3"
+
+	cleaned := cleanMultiValues(v)
+
+	assert.Equal(t, "1 | 2 | 3", cleaned)
+
+	assert.Equal(t, `"a|b" | c`, cleanMultiValues(`"a|b"|c`))
+	assert.Equal(t, `"a # b = c | d"`, cleanMultiValues(`"a # b = c | d"`))
+}
+
+func TestVariantValues(t *testing.T) {
+	tests := map[string][]string{
+		"a | b | c":       {"a", "b", "c"},
+		"a|b||c ":         {"a", "b", "", "c"},
+		`"a | b"`:         {`"a | b"`},
+		`^(foo|bar)$ | x`: {`^(foo|bar)$`, "x"},
+		"single":          {"single"},
+		"":                {""},
+	}
+
+	for value, want := range tests {
+		assert.Equal(t, want, Variant{Value: value}.Values(), value)
+	}
+
+	v := Variant{Key: "hosts", Value: "a|b"}
+	v.SetValues(append(v.Values(), "c"))
+	assert.Equal(t, "a | b | c", v.Value)
+}
+
+func TestRegexValueNotMutated(t *testing.T) {
+	input := `match = ^(foo|bar)$
+set   = [a|b] | {c|d}|e
+
+`
+
+	settings, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	err = Format(buf, settings)
+	require.NoError(t, err)
+
+	assert.Equal(t, `match = ^(foo|bar)$
+
+set = [a|b] | {c|d} | e
+
+`, buf.String())
+}
+
+func TestNoPipeNormalize(t *testing.T) {
+	settings, err := Parse(strings.NewReader("expr = a|b||c\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	err = FormatWithOptions(buf, settings, FormatOptions{NoPipeNormalize: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, "expr = a|b||c\n\n", buf.String())
+}
+
+func TestQuotedValueRoundTrip(t *testing.T) {
+	input := `q = "a # b = c | d" # note
+
+`
+
+	settings, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(settings))
+	assert.Equal(t, `"a # b = c | d"`, settings[0].Variants[0].Value)
+	assert.Equal(t, "note", settings[0].Variants[0].Comment)
+
+	buf := &bytes.Buffer{}
+	err = Format(buf, settings)
+	require.NoError(t, err)
+
+	assert.Equal(t, input, buf.String())
+}
+
+func TestComments(t *testing.T) {
+	reader := strings.NewReader(`
+		a=2
+		A=1
+		#The following section is c
+		c=3 #this is the default value
+		c.dev=1
+		#c.test=2
+		c.prod=3
+	`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	Sort(settings)
+
+	// Write settings to a string buffer
+	buf := &bytes.Buffer{}
+	err = Format(buf, settings)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, len(settings))
+	assert.Equal(t, `A = 1
+
+a = 2
+
+# The following section is c
+c        = 3 # this is the default value
+c.dev    = 1
+# c.test = 2
+c.prod   = 3
+
+`, buf.String())
+}
+
+func TestProcessLine(t *testing.T) {
+	test := []struct {
+		line string
+		want *Variant
+	}{
+		{
+			line: "#a=b",
+			want: &Variant{
+				Commented: true,
+				Key:       "a",
+				Value:     "b",
+			},
+		},
+		{
+			line: "a=b #comment",
+			want: &Variant{
+				Commented: false,
+				Key:       "a",
+				Value:     "b",
+				Comment:   "comment",
+			},
+		},
+		{
+			line: "#comment",
+			want: nil,
+		},
+		{
+			line: `color = "#ff0000" # red`,
+			want: &Variant{
+				Key:     "color",
+				Value:   `"#ff0000"`,
+				Comment: "red",
+			},
+		},
+		{
+			line: `url = http://x/\#section`,
+			want: &Variant{
+				Key:   "url",
+				Value: `http://x/\#section`,
+			},
+		},
+	}
+
+	for _, tt := range test {
+		t.Run(tt.line, func(t *testing.T) {
+			setting := processLine(tt.line, DefaultCommentChar, '=', false)
+			assert.Equal(t, tt.want, setting)
+		})
+	}
+}
+
+func TestParseKeepsFirstSeenOrder(t *testing.T) {
+	reader := strings.NewReader(`
+		zeta=1
+		alpha=2
+		zeta.dev=3
+		Beta=4
+	`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	require.Equal(t, 3, len(settings))
+	assert.Equal(t, "zeta", settings[0].Key)
+	assert.Equal(t, "alpha", settings[1].Key)
+	assert.Equal(t, "Beta", settings[2].Key)
+
+	buf := &bytes.Buffer{}
+	err = Format(buf, settings)
+	require.NoError(t, err)
+
+	assert.Equal(t, `zeta     = 1
+zeta.dev = 3
+
+alpha = 2
+
+Beta = 4
+
+`, buf.String())
+}
+
+func TestTrailingComment(t *testing.T) {
+	reader := strings.NewReader(`
+		b=2
+		# TODO: remove deprecated keys below
+		a=1
+		# footer
+	`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	Sort(settings)
+
+	buf := &bytes.Buffer{}
+	err = Format(buf, settings)
+	require.NoError(t, err)
+
+	expected := `# TODO: remove deprecated keys below
+a = 1
+
+b = 2
+
+# footer
+
+`
+	assert.Equal(t, expected, buf.String())
+
+	// The trailing comment must survive a second pass.
+	settings, err = Parse(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+
+	Sort(settings)
+
+	buf.Reset()
+	err = Format(buf, settings)
+	require.NoError(t, err)
+
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestEmptyKey(t *testing.T) {
+	_, err := Parse(strings.NewReader("a=1\n= value\n"))
+	assert.EqualError(t, err, `line 2: empty key in "= value"`)
+
+	_, err = Parse(strings.NewReader(".foo = bar\n"))
+	assert.EqualError(t, err, `line 1: empty key in ".foo = bar"`)
+
+	settings, err := Parse(strings.NewReader("# ======\na=1\n"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(settings))
+	assert.Equal(t, "======", settings[0].Comments)
+}
+
+func TestSortEmptyKey(t *testing.T) {
+	settings := []*Setting{
+		{Key: "b", Variants: []Variant{{Key: "b", Value: "2"}}},
+		{Key: "", Variants: []Variant{{Key: "", Value: "value"}}},
+		{Key: "a", Variants: []Variant{{Key: "a", Value: "1"}}},
+	}
+
+	assert.NotPanics(t, func() { Sort(settings) })
+
+	assert.Equal(t, "", settings[0].Key)
+	assert.Equal(t, "a", settings[1].Key)
+	assert.Equal(t, "b", settings[2].Key)
+}
+
+func TestSortMultibyteKeys(t *testing.T) {
+	reader := strings.NewReader(`
+		ábc.host=1
+		a=2
+		Über.host=3
+		Z=4
+	`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	Sort(settings)
+
+	keys := make([]string, 0, len(settings))
+	for _, setting := range settings {
+		keys = append(keys, setting.Key)
+	}
+
+	assert.Equal(t, []string{"Z", "Über", "a", "ábc"}, keys)
+}
+
+func TestHashValuesRoundTrip(t *testing.T) {
+	input := `color = "#ff0000" # red
+url   = http://x/\#section
+
+`
+
+	settings, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+
+	Sort(settings)
+
+	buf := &bytes.Buffer{}
+	err = Format(buf, settings)
+	require.NoError(t, err)
+
+	assert.Equal(t, `color = "#ff0000" # red
+
+url = http://x/\#section
+
+`, buf.String())
+}
+
+func TestContinuationLines(t *testing.T) {
+	input := `hosts = alpha.example.com | \
+        beta.example.com | \
+        gamma.example.com # all hosts
+other = 1
+`
+
+	settings, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+
+	require.Equal(t, 2, len(settings))
+	assert.Equal(t, Variant{
+		Key:     "hosts",
+		Value:   "alpha.example.com | beta.example.com | gamma.example.com",
+		Comment: "all hosts",
+		Line:    1,
+	}, settings[0].Variants[0])
+	assert.Equal(t, 4, settings[1].Variants[0].Line)
+
+	opts := FormatOptions{WrapWidth: 40}
+
+	buf := &bytes.Buffer{}
+	err = FormatWithOptions(buf, settings, opts)
+	require.NoError(t, err)
+
+	expected := `hosts = alpha.example.com | \
+        beta.example.com | \
+        gamma.example.com # all hosts
+
+other = 1
+
+`
+	assert.Equal(t, expected, buf.String())
+
+	// Wrapping must be stable across runs.
+	settings, err = Parse(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+
+	buf.Reset()
+	err = FormatWithOptions(buf, settings, opts)
+	require.NoError(t, err)
+
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestContinuationWithoutWrap(t *testing.T) {
+	settings, err := Parse(strings.NewReader("a = 1 | \\\n  2\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	err = Format(buf, settings)
+	require.NoError(t, err)
+
+	assert.Equal(t, "a = 1 | 2\n\n", buf.String())
+}
+
+func TestTrailingBackslash(t *testing.T) {
+	// The escaped space is trimmed from the value, leaving a backslash that
+	// would continue the line if it were written last.
+	settings, err := Parse(strings.NewReader("a = x\\ #\nb = 1\n"))
+	require.NoError(t, err)
+	assert.Equal(t, `x\`, settings[0].Variants[0].Value)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+	assert.Equal(t, "a = x\\ #\n\nb = 1\n\n", buf.String())
+
+	again, err := Parse(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+	require.Len(t, again, 2)
+	assert.Equal(t, `x\`, again[0].Variants[0].Value)
+	assert.Equal(t, "1", again[1].Variants[0].Value)
+}
+
+func TestAlignComments(t *testing.T) {
+	reader := strings.NewReader(`
+		c=3 #this is the default value
+		c.dev=100 # dev
+		#c.test=2 # test
+		c.prod=3
+	`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	err = FormatWithOptions(buf, settings, FormatOptions{AlignComments: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, `c        = 3   # this is the default value
+c.dev    = 100 # dev
+# c.test = 2   # test
+c.prod   = 3
+
+`, buf.String())
+
+	column := -1
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		i := strings.LastIndex(line, " # ")
+		if i < 0 {
+			continue
+		}
+
+		if column < 0 {
+			column = i
+		}
+
+		assert.Equal(t, column, i, line)
+	}
+}
+
+func TestMalformedLine(t *testing.T) {
+	var diagnostics []Diagnostic
+
+	reader := strings.NewReader(`a = 1
+db host x
+b = 2
+`)
+
+	settings, err := ParseWithOptions(reader, ParseOptions{
+		Warn: func(d Diagnostic) {
+			diagnostics = append(diagnostics, d)
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []Diagnostic{
+		{Line: 2, Severity: SeverityInfo, Message: `"db host x" is neither a setting nor a comment`},
+	}, diagnostics)
+
+	// The line is kept, in full, as a comment.
+	require.Equal(t, 2, len(settings))
+	assert.Equal(t, "db host x", settings[1].Comments)
+}
+
+func TestParseWithDiagnostics(t *testing.T) {
+	reader := strings.NewReader(`name = "app
+db..host = a
+db..host = b
+# db.port = 5432
+db.port = 5432
+not a setting
+user =
+`)
+
+	settings, diagnostics, err := ParseWithDiagnostics(reader, ParseOptions{})
+	require.NoError(t, err)
+	require.Len(t, settings, 3)
+
+	assert.Equal(t, []Diagnostic{
+		{Line: 1, Message: `value of "name" has an unbalanced double quote`},
+		{Line: 2, Message: `key "db..host" has an empty segment`},
+		{Line: 3, Message: `duplicate key "db..host" (first seen on line 2)`},
+		{Line: 3, Message: `key "db..host" has an empty segment`},
+		{Line: 4, Message: `commented key "db.port" repeats the live value on line 5 and can be removed`},
+		{Line: 6, Severity: SeverityInfo, Message: `"not a setting" is neither a setting nor a comment`},
+		{Line: 7, Message: `value of "user" is empty`},
+	}, diagnostics)
+
+	assert.Equal(t, "line 6: info: \"not a setting\" is neither a setting nor a comment", diagnostics[5].String())
+
+	_, _, err = ParseWithDiagnostics(strings.NewReader(".x = 1\n"), ParseOptions{})
+	assert.EqualError(t, err, `line 1: empty key in ".x = 1"`)
+}
+
+func TestPreserveBlanks(t *testing.T) {
+	input := `
+		db.host = a
+		db.port = 1
+
+
+		db.host.prod = b
+		other = 1
+
+		db.port.prod = 2
+	`
+
+	settings, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+
+	Sort(settings)
+
+	buf := &bytes.Buffer{}
+	err = FormatWithOptions(buf, settings, FormatOptions{PreserveBlanks: true})
+	require.NoError(t, err)
+
+	expected := `db.host      = a
+db.port      = 1
+
+db.host.prod = b
+
+db.port.prod = 2
+
+other = 1
+
+`
+	assert.Equal(t, expected, buf.String())
+
+	settings, err = Parse(strings.NewReader(expected))
+	require.NoError(t, err)
+
+	buf.Reset()
+	err = FormatWithOptions(buf, settings, FormatOptions{PreserveBlanks: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, expected, buf.String())
+
+	// Without the option blank lines inside a setting are collapsed.
+	buf.Reset()
+	err = Format(buf, settings)
+	require.NoError(t, err)
+
+	assert.Equal(t, `db.host      = a
+db.port      = 1
+db.host.prod = b
+db.port.prod = 2
+
+other = 1
+
+`, buf.String())
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("GOCORE_FORMAT_HOME", "/home/user")
+	t.Setenv("GOCORE_FORMAT_PORT", "8080")
+
+	reader := strings.NewReader(`
+		dir = ${GOCORE_FORMAT_HOME}/data # $GOCORE_FORMAT_PORT
+		url = "http://localhost:$GOCORE_FORMAT_PORT/"
+		price = \$5
+		unset = [${GOCORE_FORMAT_UNSET}] $GOCORE_FORMAT_UNSET
+	`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	err = FormatWithOptions(buf, settings, FormatOptions{ExpandEnv: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, `dir = /home/user/data # $GOCORE_FORMAT_PORT
+
+url = "http://localhost:8080/"
+
+price = \$5
+
+unset = []
+
+`, buf.String())
+
+	buf.Reset()
+	err = FormatWithOptions(buf, settings, FormatOptions{ExpandEnv: true, KeepUnsetEnv: true})
+	require.NoError(t, err)
+
+	assert.Contains(t, buf.String(), "unset = [${GOCORE_FORMAT_UNSET}] $GOCORE_FORMAT_UNSET\n")
+}
+
+func TestIndent(t *testing.T) {
+	reader := strings.NewReader(`
+		service = 1
+		service.db = 2
+		#service.db.pool = 3
+		service.db.pool.max = 4
+		#service.x = 5
+	`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	err = FormatWithOptions(buf, settings, FormatOptions{Indent: true})
+	require.NoError(t, err)
+
+	expected := `service                = 1
+ service.db            = 2
+#   service.db.pool    = 3
+   service.db.pool.max = 4
+#  service.x           = 5
+
+`
+	assert.Equal(t, expected, buf.String())
+
+	settings, err = Parse(strings.NewReader(expected))
+	require.NoError(t, err)
+
+	buf.Reset()
+	err = FormatWithOptions(buf, settings, FormatOptions{Indent: true})
+	require.NoError(t, err)
+
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestCommentParagraphs(t *testing.T) {
+	reader := strings.NewReader(`
+		# Database settings
+
+		# The host is overridden per context.
+		# Keep prod last.
+		db = localhost
+	`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+	require.Len(t, settings, 1)
+	assert.Equal(t, "Database settings\n\nThe host is overridden per context.\nKeep prod last.", settings[0].Comments)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+
+	expected := `# Database settings
+
+# The host is overridden per context.
+# Keep prod last.
+db = localhost
+
+`
+	assert.Equal(t, expected, buf.String())
+
+	settings, err = Parse(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+
+	again := &bytes.Buffer{}
+	require.NoError(t, Format(again, settings))
+	assert.Equal(t, expected, again.String())
+}
+
+func TestMultiLineComments(t *testing.T) {
+	settings := []*Setting{
+		{
+			Key:      "a",
+			Comments: "first\nsecond\nthird",
+			Variants: []Variant{{Key: "a", Value: "1"}},
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+
+	assert.Equal(t, "# first\n# second\n# third\na = 1\n\n", buf.String())
+
+	parsed, err := Parse(strings.NewReader(buf.String()))
+	require.NoError(t, err)
+	require.Len(t, parsed, 1)
+	assert.Equal(t, settings[0].Comments, parsed[0].Comments)
+	assert.Len(t, parsed[0].Variants, 1)
+}
+
+func TestWidth(t *testing.T) {
+	settings, err := Parse(strings.NewReader(`
+		a = 1
+		a.dev = 2
+		database.host = localhost
+		# b.test = 3
+		b = 4
+	`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		opts     FormatOptions
+		expected string
+	}{
+		{
+			name: "global max",
+			opts: FormatOptions{AlignAll: true},
+			expected: `a             = 1
+a.dev         = 2
+
+database.host = localhost
+
+# b.test      = 3
+b             = 4
+
+`,
+		},
+		{
+			name: "fixed width smaller than some keys",
+			opts: FormatOptions{Width: 6},
+			expected: `a      = 1
+a.dev  = 2
+
+database.host = localhost
+
+# b.test = 3
+b      = 4
+
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			require.NoError(t, FormatWithOptions(buf, settings, tt.opts))
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+}
+
+func TestCommentChar(t *testing.T) {
+	reader := strings.NewReader(`
+		; Database
+		db.host = localhost ; the default
+		; db.host.dev = dev.local
+		#hash = 1
+		url = http://example.com/#anchor
+	`)
+
+	settings, err := ParseWithOptions(reader, ParseOptions{CommentChar: ';'})
+	require.NoError(t, err)
+
+	Sort(settings)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, FormatWithOptions(buf, settings, FormatOptions{CommentChar: ';'}))
+
+	expected := `#hash = 1
+
+; Database
+db.host       = localhost ; the default
+; db.host.dev = dev.local
+
+url = http://example.com/#anchor
+
+`
+	assert.Equal(t, expected, buf.String())
+
+	settings, err = ParseWithOptions(strings.NewReader(buf.String()), ParseOptions{CommentChar: ';'})
+	require.NoError(t, err)
+
+	again := &bytes.Buffer{}
+	require.NoError(t, FormatWithOptions(again, settings, FormatOptions{CommentChar: ';'}))
+	assert.Equal(t, expected, again.String())
+}
+
+func TestKeyWhitespace(t *testing.T) {
+	settings, err := Parse(strings.NewReader("db . host = a\n"))
+	require.NoError(t, err)
+	require.Len(t, settings, 1)
+	assert.Equal(t, "db.host", settings[0].Variants[0].Key)
+
+	_, err = Parse(strings.NewReader("a = 1\ndb host.port = 2\n"))
+	assert.EqualError(t, err, `line 2: key "db host.port" contains whitespace`)
+
+	settings, err = Parse(strings.NewReader("# Note: db host = 1\na = 1\n"))
+	require.NoError(t, err)
+	require.Len(t, settings, 1)
+	assert.Equal(t, "Note: db host = 1", settings[0].Comments)
+	assert.Len(t, settings[0].Variants, 1)
+}
+
+func TestInlineCommentCanonical(t *testing.T) {
+	tests := []struct {
+		line     string
+		comment  string
+		expected string
+	}{
+		{"x = 1 # a # b", "a # b", "x = 1 # a # b"},
+		{"x = 1 #a#b", "a#b", "x = 1 # a#b"},
+		{"x = 1 ## note", "# note", "x = 1 ## note"},
+		{"x = 1 ##note", "# note", "x = 1 ## note"},
+		{"x = 1 # # note", "# note", "x = 1 ## note"},
+		{"x = 1 #   spaced   out  ", "spaced   out", "x = 1 # spaced   out"},
+		{"x = 1 ###", "##", "x = 1 ###"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			settings, err := Parse(strings.NewReader(tt.line))
+			require.NoError(t, err)
+			assert.Equal(t, tt.comment, settings[0].Variants[0].Comment)
+
+			buf := &bytes.Buffer{}
+			require.NoError(t, Format(buf, settings))
+			assert.Equal(t, tt.expected+"\n\n", buf.String())
+
+			settings, err = Parse(strings.NewReader(buf.String()))
+			require.NoError(t, err)
+
+			again := &bytes.Buffer{}
+			require.NoError(t, Format(again, settings))
+			assert.Equal(t, buf.String(), again.String())
+		})
+	}
+}
+
+func TestInlineCommentWithHashes(t *testing.T) {
+	input := "x = 1 # see #123 in tracker, #456 too\n\n"
+
+	settings, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Len(t, settings[0].Variants, 1)
+	assert.Equal(t, "1", settings[0].Variants[0].Value)
+	assert.Equal(t, "see #123 in tracker, #456 too", settings[0].Variants[0].Comment)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+	assert.Equal(t, input, buf.String())
+}
+
+func TestValueTransform(t *testing.T) {
+	settings, err := Parse(strings.NewReader(`
+		host = example.com # lower
+		host.dev = localhost|other
+		# host.prod = prod.example.com
+	`))
+	require.NoError(t, err)
+
+	upper := func(key, value string) string {
+		return strings.ToUpper(value)
+	}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, FormatWithOptions(buf, settings, FormatOptions{ValueTransform: upper}))
+
+	assert.Equal(t, `host        = EXAMPLE.COM # lower
+host.dev    = LOCALHOST | OTHER
+# host.prod = prod.example.com
+
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, FormatWithOptions(buf, settings, FormatOptions{ValueTransform: upper, TransformCommented: true}))
+
+	assert.Contains(t, buf.String(), "# host.prod = PROD.EXAMPLE.COM\n")
+}
+
+func TestSortValues(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"b | a | b", "a | b"},
+		{"c|a|b", "a | b | c"},
+		{"single", "single"},
+		{`"b|a" | a`, `"b|a" | a`},
+		{`^(foo|bar)$ | a`, `^(foo|bar)$ | a`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			settings := []*Setting{{Key: "x", Variants: []Variant{{Key: "x", Value: tt.value}}}}
+
+			buf := &bytes.Buffer{}
+			require.NoError(t, FormatWithOptions(buf, settings, FormatOptions{SortValues: true}))
+			assert.Equal(t, "x = "+tt.expected+"\n\n", buf.String())
+		})
+	}
+}
+
+// formatted parses, sorts and formats input the way the command does.
+func formatted(t testing.TB, input string) (string, bool) {
+	t.Helper()
+
+	settings, err := Parse(strings.NewReader(input))
+	if err != nil {
+		return "", false
+	}
+
+	Sort(settings)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+
+	return buf.String(), true
+}
+
+func TestIdempotent(t *testing.T) {
+	inputs := []string{
+		"b=2\na=1\n",
+		"# one\n\n# two\na = 1\n",
+		"a = x|  y |z # note ## more\n",
+		"a = 1 \\\n  | 2\n",
+		"#\n#\na = 1\n#\n",
+		"a.dev = 1\n# note\n\n# more\na.prod = 2\n",
+		"a = \"quoted # not a comment\" # comment\n",
+		"junk line\na = 1\n",
+		"a.dev = 1\n# a = 2\nA = 3\n",
+		"0\n#",
+		"0 \\",
+	}
+
+	for _, input := range inputs {
+		once, ok := formatted(t, input)
+		require.True(t, ok, input)
+
+		twice, ok := formatted(t, once)
+		require.True(t, ok, once)
+
+		assert.Equal(t, once, twice, "input %q", input)
+	}
+}
+
+func FuzzIdempotent(f *testing.F) {
+	f.Add("b=2\na=1\n")
+	f.Add("# one\n\n# two\na = 1 # x ## y\n")
+	f.Add("a = x|y \\\n|z\n# a.dev = \"q#\"\n")
+	f.Add("[section]\nkey = value\n")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		once, ok := formatted(t, input)
+		if !ok {
+			return
+		}
+
+		twice, ok := formatted(t, once)
+		if !ok {
+			t.Fatalf("formatted output does not parse:\n%s", once)
+		}
+
+		if once != twice {
+			t.Fatalf("not idempotent for %q:\nonce:\n%s\ntwice:\n%s", input, once, twice)
+		}
+	})
+}
+
+func TestCRLF(t *testing.T) {
+	input := "# Section\r\nb = 2 # note\r\n# b.dev = 3\r\na = x|\\\r\n  y\r\n"
+
+	settings, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+
+	for _, setting := range settings {
+		assert.NotContains(t, setting.Comments, "\r")
+
+		for _, variant := range setting.Variants {
+			assert.NotContains(t, variant.Key, "\r")
+			assert.NotContains(t, variant.Value, "\r")
+			assert.NotContains(t, variant.Comment, "\r")
+		}
+	}
+
+	Sort(settings)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+
+	assert.Equal(t, "a = x | y\n\n# Section\nb       = 2 # note\n# b.dev = 3\n\n", buf.String())
+}
+
+func TestLongLine(t *testing.T) {
+	value := strings.Repeat("host.example.com|", 5000)
+	require.Greater(t, len(value), 64*1024)
+
+	settings, err := Parse(strings.NewReader("a = 1\nhosts = " + value + "\n"))
+	require.NoError(t, err)
+	require.Len(t, settings, 2)
+	assert.Equal(t, value, settings[1].Variants[0].Value)
+
+	_, err = Parse(strings.NewReader("a = 1\nb = " + strings.Repeat("x", MaxLineSize) + "\n"))
+	assert.EqualError(t, err, fmt.Sprintf("line 2: longer than %d bytes", MaxLineSize))
+}
+
+func TestNoTrailingWhitespace(t *testing.T) {
+	settings, err := Parse(strings.NewReader(`
+		# Section
+		key =
+		key.dev = 1 # note
+		key.prod =   # empty with comment
+		# key.test =
+		other = x|y
+	`))
+	require.NoError(t, err)
+
+	settings = append(settings, &Setting{Comments: "trailing   \n\nspaces "})
+
+	for _, opts := range []FormatOptions{{}, {AlignComments: true}, {Indent: true}, {WrapWidth: 5}, {AlignAll: true}} {
+		buf := &bytes.Buffer{}
+		require.NoError(t, FormatWithOptions(buf, settings, opts))
+
+		for i, line := range strings.Split(buf.String(), "\n") {
+			assert.Equal(t, strings.TrimRight(line, " \t"), line, "options %+v, line %d", opts, i+1)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings[:1]))
+	assert.Equal(t, "# Section\nkey        =\nkey.dev    = 1 # note\nkey.prod   = # empty with comment\n# key.test =\n\n", buf.String())
+}
+
+func TestEmptyValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"live", "key =", "key =\n\n"},
+		{"live without spaces", "key=", "key =\n\n"},
+		{"commented", "#key =", "# key =\n\n"},
+		{"quoted", `key = ""`, "key = \"\"\n\n"},
+		{
+			name:     "mixed block",
+			input:    "key = value\nkey.dev =\n# key.prod =\nkey.test = \"\" # quoted",
+			expected: "key        = value\nkey.dev    =\n# key.prod =\nkey.test   = \"\" # quoted\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := Parse(strings.NewReader(tt.input))
+			require.NoError(t, err)
+
+			buf := &bytes.Buffer{}
+			require.NoError(t, Format(buf, settings))
+			assert.Equal(t, tt.expected, buf.String())
+
+			settings, err = Parse(strings.NewReader(buf.String()))
+			require.NoError(t, err)
+
+			again := &bytes.Buffer{}
+			require.NoError(t, Format(again, settings))
+			assert.Equal(t, tt.expected, again.String())
+		})
+	}
+}
+
+func TestSections(t *testing.T) {
+	reader := strings.NewReader(`
+		version = 1
+		# [Database]
+		db.port = 5432
+		db.host = localhost
+		# Connections
+		pool = 10
+		# [Cache]
+		ttl = 60
+		cache = redis
+		# [Database]
+		replica = db2
+	`)
+
+	settings, err := ParseWithOptions(reader, ParseOptions{Sections: true})
+	require.NoError(t, err)
+
+	SortWithOptions(settings, SortOptions{BySection: true})
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+
+	expected := `version = 1
+
+# [Database]
+
+db.port = 5432
+db.host = localhost
+
+# Connections
+pool = 10
+
+replica = db2
+
+# [Cache]
+
+cache = redis
+
+ttl = 60
+
+`
+	assert.Equal(t, expected, buf.String())
+
+	settings, err = ParseWithOptions(strings.NewReader(buf.String()), ParseOptions{Sections: true})
+	require.NoError(t, err)
+
+	SortWithOptions(settings, SortOptions{BySection: true})
+
+	again := &bytes.Buffer{}
+	require.NoError(t, Format(again, settings))
+	assert.Equal(t, expected, again.String())
+}
+
+func TestCommentedAlignment(t *testing.T) {
+	settings, err := Parse(strings.NewReader(`
+		a = 1
+		# a.b = 2
+		a.much.longer = 3
+		# a.very.much.longer.commented = 4
+		# Ünïcode = 5
+		Ünïcode.dev = 6
+	`))
+	require.NoError(t, err)
+
+	for _, opts := range []FormatOptions{{}, {CommentChar: ';'}, {Indent: true}, {AlignAll: true}} {
+		buf := &bytes.Buffer{}
+		require.NoError(t, FormatWithOptions(buf, settings, opts))
+
+		for _, block := range strings.Split(strings.TrimSpace(buf.String()), "\n\n") {
+			column := -1
+
+			for _, line := range strings.Split(block, "\n") {
+				i := strings.Index(line, " = ")
+				require.GreaterOrEqual(t, i, 0, line)
+
+				c := utf8.RuneCountInString(line[:i])
+				if column < 0 {
+					column = c
+				}
+
+				assert.Equal(t, column, c, "options %+v:\n%s", opts, block)
+			}
+		}
+	}
+}
+
+func TestNoAlign(t *testing.T) {
+	settings, err := Parse(strings.NewReader(`
+		a = 1
+		a.much.longer.key = 2 # note
+		# a.dev = 3
+		b=4
+	`))
+	require.NoError(t, err)
+
+	for _, opts := range []FormatOptions{{NoAlign: true}, {NoAlign: true, Width: 30}, {NoAlign: true, AlignAll: true}} {
+		buf := &bytes.Buffer{}
+		require.NoError(t, FormatWithOptions(buf, settings, opts))
+
+		assert.Equal(t, "a = 1\na.much.longer.key = 2 # note\n# a.dev = 3\n\nb = 4\n\n", buf.String())
+	}
+}
+
+func TestTabs(t *testing.T) {
+	input := "# a\tcomment\n\tdb.host\t=\tlocal\thost\t# inline\tnote\n\tdb.port = 1\n"
+
+	settings, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+
+	assert.NotContains(t, buf.String(), "\t")
+	assert.Equal(t, "# a comment\ndb.host = local host # inline note\ndb.port = 1\n\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, FormatWithOptions(buf, settings, FormatOptions{TabWidth: 4}))
+
+	assert.Equal(t, "# a    comment\ndb.host = local    host # inline    note\ndb.port = 1\n\n", buf.String())
+}
+
+func TestDedupeComments(t *testing.T) {
+	input := `# Database host
+db.host = a
+other = 1
+# Database host
+# Port for prod
+db.port.prod = 2
+# Shared
+z = 3
+# Port for prod
+
+db.z = 4
+`
+
+	settings, err := ParseWithOptions(strings.NewReader(input), ParseOptions{DedupeComments: true})
+	require.NoError(t, err)
+
+	require.Len(t, settings, 3)
+	assert.Equal(t, "Database host\n\nPort for prod", settings[0].Comments)
+	assert.Equal(t, "Shared", settings[2].Comments)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+
+	assert.Equal(t, `# Database host
+
+# Port for prod
+db.host      = a
+db.port.prod = 2
+db.z         = 4
+
+other = 1
+
+# Shared
+z = 3
+
+`, buf.String())
+}
+
+func TestRepeatedRootKeyComments(t *testing.T) {
+	input := `# Where the database lives
+db.host = a
+other = 1
+# Where the database lives
+# Only prod uses TLS
+db.tls.prod = true
+`
+
+	settings, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+
+	require.Len(t, settings, 2)
+	assert.Equal(t, "Where the database lives\n\nWhere the database lives\nOnly prod uses TLS", settings[0].Comments)
+	assert.Empty(t, settings[1].Comments)
+}
+
+func TestSeparator(t *testing.T) {
+	input := "db.host: local # dev\n# db.port : 1\nurl: http://x:80\nurl.prod:\n"
+
+	settings, err := ParseWithOptions(strings.NewReader(input), ParseOptions{Separator: ':'})
+	require.NoError(t, err)
+
+	require.Len(t, settings, 2)
+	assert.Equal(t, "http://x:80", settings[1].Variants[0].Value)
+
+	for _, test := range []struct {
+		separator string
+		expected  string
+	}{
+		{": ", "db.host  : local # dev\n# db.port: 1\n\nurl     : http://x:80\nurl.prod:\n\n"},
+		{" : ", "db.host   : local # dev\n# db.port : 1\n\nurl      : http://x:80\nurl.prod :\n\n"},
+	} {
+		opts := FormatOptions{Separator: test.separator}
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, FormatWithOptions(buf, settings, opts))
+		assert.Equal(t, test.expected, buf.String())
+
+		// The output reads back to the same settings.
+		again, err := ParseWithOptions(strings.NewReader(buf.String()), ParseOptions{Separator: ':'})
+		require.NoError(t, err)
+
+		buf2 := &bytes.Buffer{}
+		require.NoError(t, FormatWithOptions(buf2, again, opts))
+		assert.Equal(t, buf.String(), buf2.String())
+	}
+}
+
+func TestCommentWithSeparator(t *testing.T) {
+	tests := []struct {
+		name      string
+		separator byte
+		opts      FormatOptions
+		input     string
+		expected  string
+	}{
+		{
+			name:     "equals",
+			input:    "k = a # use : not = # really\n# k.dev = b # = and #\nx # y = 1\n",
+			expected: "k       = a # use : not = # really\n# k.dev = b # = and #\n\n# x # y = 1\n\n",
+		},
+		{
+			name:      "colon",
+			separator: ':',
+			opts:      FormatOptions{Separator: ": "},
+			input:     "k: a # use : not = # really\nurl: http://x:80 # port: 80\n",
+			expected:  "k: a # use : not = # really\n\nurl: http://x:80 # port: 80\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parseOpts := ParseOptions{Separator: tt.separator}
+
+			settings, err := ParseWithOptions(strings.NewReader(tt.input), parseOpts)
+			require.NoError(t, err)
+
+			assert.Equal(t, "a", settings[0].Variants[0].Value)
+			assert.Equal(t, "use : not = # really", settings[0].Variants[0].Comment)
+
+			buf := &bytes.Buffer{}
+			require.NoError(t, FormatWithOptions(buf, settings, tt.opts))
+			assert.Equal(t, tt.expected, buf.String())
+
+			// The comments read back unchanged.
+			again, err := ParseWithOptions(strings.NewReader(buf.String()), parseOpts)
+			require.NoError(t, err)
+			assert.Equal(t, settings[0].Variants, again[0].Variants)
+		})
+	}
+}
+
+func TestLeadingComment(t *testing.T) {
+	input := `# The x setting
+x.dev = 1
+# Prod needs more
+#
+# See the runbook
+x.prod = 2
+y = 3
+# About z
+z = 4
+`
+
+	settings, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+
+	require.Len(t, settings, 3)
+	assert.Equal(t, "The x setting", settings[0].Comments)
+	assert.Equal(t, "", settings[0].Variants[0].LeadingComment)
+	assert.Equal(t, "Prod needs more\n\nSee the runbook", settings[0].Variants[1].LeadingComment)
+	assert.Equal(t, "About z", settings[2].Comments)
+
+	expected := `# The x setting
+x.dev  = 1
+# Prod needs more
+#
+# See the runbook
+x.prod = 2
+
+y = 3
+
+# About z
+z = 4
+
+`
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+	assert.Equal(t, expected, buf.String())
+
+	// The comment stays with x.prod when the variants are reordered.
+	SortWithOptions(settings, SortOptions{SortVariants: true, ContextOrder: []string{"prod", "dev"}})
+
+	buf.Reset()
+	require.NoError(t, Format(buf, settings))
+	assert.Equal(t, "# The x setting\n# Prod needs more\n#\n# See the runbook\nx.prod = 2\nx.dev  = 1\n\ny = 3\n\n# About z\nz = 4\n\n", buf.String())
+
+	again, ok := formatted(t, expected)
+	require.True(t, ok)
+	assert.Equal(t, expected, again)
+}
+
+func TestByteOrderMark(t *testing.T) {
+	settings, err := Parse(strings.NewReader("\ufeffdb.host = a\ncache = b\ndb.port = 1\n"))
+	require.NoError(t, err)
+
+	require.Len(t, settings, 2)
+	assert.Equal(t, "db", settings[0].Key)
+	assert.Equal(t, "db.host", settings[0].Variants[0].Key)
+	assert.Len(t, settings[0].Variants, 2)
+
+	// A mark before a comment is dropped as well.
+	settings, err = Parse(strings.NewReader("\ufeff# note\na = 1\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "note", settings[0].Comments)
+}
+
+func TestRawValues(t *testing.T) {
+	input := "banner = `| a  |  b |   # not a comment`   # a comment\nlist = x|y\ntable = `col1   col2`\n"
+
+	settings, err := ParseWithOptions(strings.NewReader(input), ParseOptions{RawValues: true})
+	require.NoError(t, err)
+
+	require.Len(t, settings, 3)
+	assert.Equal(t, "`| a  |  b |   # not a comment`", settings[0].Variants[0].Value)
+	assert.Equal(t, "a comment", settings[0].Variants[0].Comment)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, FormatWithOptions(buf, settings, FormatOptions{RawValues: true, SortValues: true, WrapWidth: 10}))
+
+	assert.Equal(t, "banner = `| a  |  b |   # not a comment` # a comment\n\n"+
+		"list = x | \\\n       y\n\n"+
+		"table = `col1   col2`\n\n", buf.String())
+
+	// Without the option the backticks are ordinary characters.
+	settings, err = Parse(strings.NewReader(input))
+	require.NoError(t, err)
+
+	assert.Equal(t, "`| a  |  b |", settings[0].Variants[0].Value)
+}
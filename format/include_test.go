@@ -0,0 +1,152 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func parseFile(t *testing.T, filename string) ([]*Setting, error) {
+	t.Helper()
+
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	defer f.Close()
+
+	return ParseWithOptions(f, ParseOptions{Includes: true, Filename: filename})
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFiles(t, dir, map[string]string{
+		"main.conf": "db.host = main\n#include base.conf\ncache = 1\n",
+		"base.conf": "db.port = 5432\n# Base only\nbase = 1\n",
+	})
+
+	settings, err := parseFile(t, filepath.Join(dir, "main.conf"))
+	require.NoError(t, err)
+
+	Sort(settings)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+
+	assert.Equal(t, `# Base only
+base = 1
+
+cache = 1
+
+db.host = main
+db.port = 5432
+
+`, buf.String())
+
+	// Included variants take the line of the include directive.
+	assert.Equal(t, 2, settings[0].Variants[0].Line)
+}
+
+func TestIncludeRelativePath(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFiles(t, dir, map[string]string{
+		"main.conf":             "@include shared/net.conf\n",
+		"shared/net.conf":       "#include \"sub/ports.conf\"\nhost = a\n",
+		"shared/sub/ports.conf": "port = 1\n",
+	})
+
+	settings, err := parseFile(t, filepath.Join(dir, "main.conf"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"port", "host"}, settingKeys(settings))
+}
+
+func TestIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFiles(t, dir, map[string]string{
+		"a.conf": "a = 1\n#include b.conf\n",
+		"b.conf": "b = 1\n#include a.conf\n",
+	})
+
+	_, err := parseFile(t, filepath.Join(dir, "a.conf"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "include cycle: "+filepath.Join(dir, "a.conf")+" -> "+filepath.Join(dir, "b.conf")+" -> "+filepath.Join(dir, "a.conf"))
+}
+
+func TestIncludeDepthLimit(t *testing.T) {
+	dir := t.TempDir()
+
+	files := make(map[string]string)
+	for i := 0; i <= MaxIncludeDepth+1; i++ {
+		files[fmt.Sprintf("%d.conf", i)] = fmt.Sprintf("#include %d.conf\n", i+1)
+	}
+
+	files[fmt.Sprintf("%d.conf", MaxIncludeDepth+2)] = "a = 1\n"
+
+	writeFiles(t, dir, files)
+
+	_, err := parseFile(t, filepath.Join(dir, "0.conf"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), fmt.Sprintf("includes nested more than %d deep", MaxIncludeDepth))
+}
+
+func TestIncludeDisabled(t *testing.T) {
+	settings, err := Parse(strings.NewReader("#include other.conf\na = 1\n"))
+	require.NoError(t, err)
+
+	require.Len(t, settings, 2)
+	assert.Equal(t, &Setting{Include: "#include other.conf"}, settings[0])
+	assert.Equal(t, "a", settings[1].Key)
+}
+
+func TestIncludeFormatted(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFiles(t, dir, map[string]string{
+		"base.conf":  "port = 1\nname = base\n",
+		"extra.conf": "debug = true\n",
+	})
+
+	settings, err := Parse(strings.NewReader("z = 1\n# The base\n#include base.conf\nport = 2\nb = 1\n@include \"extra.conf\"\na = 1\n"))
+	require.NoError(t, err)
+
+	Sort(settings)
+
+	var buf bytes.Buffer
+	require.NoError(t, Format(&buf, settings))
+
+	// The directives are kept as written, and each setting stays on its
+	// side of them.
+	assert.Equal(t, "z = 1\n\n# The base\n#include base.conf\n\nb = 1\n\nport = 2\n\n@include \"extra.conf\"\n\na = 1\n\n", buf.String())
+
+	formatted := filepath.Join(dir, "main.conf")
+	require.NoError(t, os.WriteFile(formatted, buf.Bytes(), 0644))
+
+	settings, err = parseFile(t, formatted)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a", "b", "debug", "name", "port", "z"}, Keys(settings, true))
+
+	port, ok := Settings(settings).Resolve("port", "")
+	require.True(t, ok)
+	assert.Equal(t, "2", port)
+}
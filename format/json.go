@@ -0,0 +1,125 @@
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// EncodeJSON writes settings to w as an indented JSON array, one object per
+// setting with its variants in order.
+func EncodeJSON(w io.Writer, settings []*Setting) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	if settings == nil {
+		settings = []*Setting{}
+	}
+
+	return encoder.Encode(settings)
+}
+
+// DecodeJSON reads settings from the JSON representation written by
+// EncodeJSON. It rejects what Format could not write so that it reads back
+// the same, taking '#' as the comment character: keys with whitespace, '='
+// or '#', line breaks in values and inline comments, unescaped comment
+// markers in values, and comment lines that would read as variants.
+func DecodeJSON(r io.Reader) ([]*Setting, error) {
+	var settings []*Setting
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&settings); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	for i, setting := range settings {
+		if setting == nil {
+			return nil, fmt.Errorf("setting %d: null setting", i+1)
+		}
+
+		if problem := unwritableKey(setting.Key); problem != "" && setting.Key != "" {
+			return nil, fmt.Errorf("setting %d: key %q %s", i+1, setting.Key, problem)
+		}
+
+		if line, ok := variantLine(setting.Comments); ok {
+			return nil, fmt.Errorf("setting %d (%q): comment line %q would be read as a variant", i+1, setting.Key, line)
+		}
+
+		for j, variant := range setting.Variants {
+			if problem := variantProblem(variant); problem != "" {
+				return nil, fmt.Errorf("setting %d (%q): variant %d %s", i+1, setting.Key, j+1, problem)
+			}
+		}
+
+		if setting.Include != "" {
+			if _, ok := includePath(setting.Include); !ok {
+				return nil, fmt.Errorf("setting %d: %q is not an include directive", i+1, setting.Include)
+			}
+
+			if setting.Key != "" || len(setting.Variants) > 0 {
+				return nil, fmt.Errorf("setting %d: an include directive has no key or variants", i+1)
+			}
+		}
+
+		if len(setting.Variants) > 0 && setting.Key == "" {
+			return nil, fmt.Errorf("setting %d: empty key", i+1)
+		}
+	}
+
+	return settings, nil
+}
+
+// variantProblem says what in v would not be read back as written, or
+// returns "".
+func variantProblem(v Variant) string {
+	if strings.TrimSpace(v.Key) == "" {
+		return "has an empty key"
+	}
+
+	if problem := unwritableKey(v.Key); problem != "" {
+		return fmt.Sprintf("has key %q, which %s", v.Key, problem)
+	}
+
+	if strings.ContainsAny(v.Value, "\r\n") {
+		return fmt.Sprintf("(%q) has a line break in its value", v.Key)
+	}
+
+	if _, _, found := splitComment(v.Value, '#', true); found {
+		return fmt.Sprintf("(%q) has an unescaped %q in its value", v.Key, "#")
+	}
+
+	if strings.ContainsAny(v.Comment, "\r\n") {
+		return fmt.Sprintf("(%q) has a line break in its comment", v.Key)
+	}
+
+	if line, ok := variantLine(v.LeadingComment); ok {
+		return fmt.Sprintf("(%q) has a leading comment line %q that would be read as a variant", v.Key, line)
+	}
+
+	return ""
+}
+
+// unwritableKey says why key cannot be written as a key, or returns "".
+func unwritableKey(key string) string {
+	if hasSpace(key) || strings.ContainsAny(key, "=#") {
+		return `contains whitespace, "=" or "#"`
+	}
+
+	return ""
+}
+
+// variantLine returns the first line of the comment block comments that,
+// written after a comment marker, would be read back as a commented-out
+// variant.
+func variantLine(comments string) (string, bool) {
+	for _, line := range strings.Split(comments, "\n") {
+		if line = strings.TrimSpace(line); line != "" && processLine("#"+line, '#', 0, false) != nil {
+			return line, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,249 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeJSON(t *testing.T) {
+	reader := strings.NewReader(`
+		b=2
+		#The following section is a
+		a=1 # default
+		#a.dev=3
+	`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	Sort(settings)
+
+	buf := &bytes.Buffer{}
+	err = EncodeJSON(buf, settings)
+	require.NoError(t, err)
+
+	assert.Equal(t, `[
+  {
+    "key": "a",
+    "comments": "The following section is a",
+    "variants": [
+      {
+        "commented": false,
+        "key": "a",
+        "value": "1",
+        "comment": "default"
+      },
+      {
+        "commented": true,
+        "key": "a.dev",
+        "value": "3"
+      }
+    ]
+  },
+  {
+    "key": "b",
+    "variants": [
+      {
+        "commented": false,
+        "key": "b",
+        "value": "2"
+      }
+    ]
+  }
+]
+`, buf.String())
+}
+
+func TestEncodeJSONEmpty(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := EncodeJSON(buf, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "[]\n", buf.String())
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	input := `[
+  {
+    "key": "b",
+    "variants": [
+      {
+        "commented": false,
+        "key": "b",
+        "value": "2|3"
+      }
+    ]
+  },
+  {
+    "key": "a",
+    "comments": "Section a",
+    "variants": [
+      {
+        "commented": true,
+        "key": "a.dev",
+        "value": "3",
+        "comment": "old"
+      },
+      {
+        "commented": false,
+        "key": "a",
+        "value": "1"
+      }
+    ]
+  }
+]
+`
+
+	settings, err := DecodeJSON(strings.NewReader(input))
+	require.NoError(t, err)
+
+	Sort(settings)
+
+	conf := &bytes.Buffer{}
+	err = Format(conf, settings)
+	require.NoError(t, err)
+
+	assert.Equal(t, `# Section a
+# a.dev = 3 # old
+a       = 1
+
+b = 2 | 3
+
+`, conf.String())
+
+	parsed, err := Parse(conf)
+	require.NoError(t, err)
+
+	Sort(parsed)
+
+	first := &bytes.Buffer{}
+	err = EncodeJSON(first, parsed)
+	require.NoError(t, err)
+
+	settings, err = DecodeJSON(bytes.NewReader(first.Bytes()))
+	require.NoError(t, err)
+
+	Sort(settings)
+
+	conf.Reset()
+	err = Format(conf, settings)
+	require.NoError(t, err)
+
+	parsed, err = Parse(conf)
+	require.NoError(t, err)
+
+	Sort(parsed)
+
+	second := &bytes.Buffer{}
+	err = EncodeJSON(second, parsed)
+	require.NoError(t, err)
+
+	assert.Equal(t, first.String(), second.String())
+}
+
+func TestDecodeJSONErrors(t *testing.T) {
+	_, err := DecodeJSON(strings.NewReader(`[{"key": "a", "variants": [{"key": "", "value": "1"}]}]`))
+	assert.EqualError(t, err, `setting 1 ("a"): variant 1 has an empty key`)
+
+	_, err = DecodeJSON(strings.NewReader(`[{"key": "a"`))
+	assert.ErrorContains(t, err, "invalid JSON")
+
+	_, err = DecodeJSON(strings.NewReader(`[{"key": "a", "bogus": true}]`))
+	assert.ErrorContains(t, err, "invalid JSON")
+
+	_, err = DecodeJSON(strings.NewReader(`[{"key": "", "include": "include x"}]`))
+	assert.EqualError(t, err, `setting 1: "include x" is not an include directive`)
+
+	_, err = DecodeJSON(strings.NewReader(`[{"key": "a", "include": "#include x"}]`))
+	assert.EqualError(t, err, "setting 1: an include directive has no key or variants")
+}
+
+func TestDecodeJSONUnwritable(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name:  "line break in value",
+			input: `[{"key": "a", "variants": [{"key": "a", "value": "1\nevil = 2"}]}]`,
+			err:   `setting 1 ("a"): variant 1 ("a") has a line break in its value`,
+		},
+		{
+			name:  "comment marker in value",
+			input: `[{"key": "a", "variants": [{"key": "a", "value": "1 # 2"}]}]`,
+			err:   `setting 1 ("a"): variant 1 ("a") has an unescaped "#" in its value`,
+		},
+		{
+			name:  "line break in comment",
+			input: `[{"key": "a", "variants": [{"key": "a", "value": "1", "comment": "x\nevil = 2"}]}]`,
+			err:   `setting 1 ("a"): variant 1 ("a") has a line break in its comment`,
+		},
+		{
+			name:  "variant in leading comment",
+			input: `[{"key": "a", "variants": [{"key": "a", "value": "1", "leadingComment": "note\nevil = 2"}]}]`,
+			err:   `setting 1 ("a"): variant 1 ("a") has a leading comment line "evil = 2" that would be read as a variant`,
+		},
+		{
+			name:  "variant in comment block",
+			input: `[{"key": "a", "comments": "evil = 2", "variants": [{"key": "a", "value": "1"}]}]`,
+			err:   `setting 1 ("a"): comment line "evil = 2" would be read as a variant`,
+		},
+		{
+			name:  "whitespace in key",
+			input: `[{"key": "a", "variants": [{"key": "a b", "value": "1"}]}]`,
+			err:   `setting 1 ("a"): variant 1 has key "a b", which contains whitespace, "=" or "#"`,
+		},
+		{
+			name:  "separator in key",
+			input: `[{"key": "a", "variants": [{"key": "a=b", "value": "1"}]}]`,
+			err:   `setting 1 ("a"): variant 1 has key "a=b", which contains whitespace, "=" or "#"`,
+		},
+		{
+			name:  "comment marker in setting key",
+			input: `[{"key": "a#b", "variants": [{"key": "a", "value": "1"}]}]`,
+			err:   `setting 1: key "a#b" contains whitespace, "=" or "#"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeJSON(strings.NewReader(tt.input))
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestJSONRoundTripEscapes(t *testing.T) {
+	// Multi-line comment blocks, escaped markers and quoted values are
+	// written so that they read back the same.
+	input := "# First line\n# second line\na = x\\#y # note\n# Leading\n# block\na.dev = \"p # q\"\n"
+
+	parsed, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+
+	var encoded bytes.Buffer
+	require.NoError(t, EncodeJSON(&encoded, parsed))
+
+	decoded, err := DecodeJSON(&encoded)
+	require.NoError(t, err)
+
+	var conf bytes.Buffer
+	require.NoError(t, Format(&conf, decoded))
+
+	reparsed, err := Parse(&conf)
+	require.NoError(t, err)
+
+	for i := range parsed {
+		for j := range parsed[i].Variants {
+			parsed[i].Variants[j].Line = 0
+			reparsed[i].Variants[j].Line = 0
+		}
+	}
+
+	assert.Equal(t, parsed, reparsed)
+}
@@ -0,0 +1,65 @@
+package format
+
+import (
+	"sort"
+)
+
+// Keys returns the full key of every variant, sorted and without repeats. A
+// commented-out variant is listed with a leading '#' after the live key of
+// the same name, or left out if liveOnly is set.
+func Keys(settings []*Setting, liveOnly bool) []string {
+	type entry struct {
+		key       string
+		commented bool
+	}
+
+	seen := make(map[entry]bool)
+
+	var entries []entry
+
+	for _, setting := range settings {
+		for _, variant := range setting.Variants {
+			e := entry{variant.Key, variant.Commented}
+			if seen[e] || (liveOnly && e.commented) {
+				continue
+			}
+
+			seen[e] = true
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].key != entries[j].key {
+			return entries[i].key < entries[j].key
+		}
+
+		return !entries[i].commented && entries[j].commented
+	})
+
+	keys := make([]string, 0, len(entries))
+
+	for _, e := range entries {
+		if e.commented {
+			keys = append(keys, "#"+e.key)
+		} else {
+			keys = append(keys, e.key)
+		}
+	}
+
+	return keys
+}
+
+// Count returns the number of settings with at least one variant, leaving
+// out comment blocks such as the one at the end of a file, and the number of
+// variants, live or commented out.
+func Count(settings []*Setting) (keys, variants int) {
+	for _, setting := range settings {
+		if len(setting.Variants) > 0 {
+			keys++
+			variants += len(setting.Variants)
+		}
+	}
+
+	return keys, variants
+}
@@ -0,0 +1,36 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeys(t *testing.T) {
+	reader := strings.NewReader(`
+		db.prod = b
+		# Section
+		cache = 1
+		db = a
+		# db.prod = old
+		db = again
+		# cache.dev = 2
+	`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"cache", "#cache.dev", "db", "db.prod", "#db.prod"}, Keys(settings, false))
+	assert.Equal(t, []string{"cache", "db", "db.prod"}, Keys(settings, true))
+}
+
+func TestCount(t *testing.T) {
+	settings, err := Parse(strings.NewReader("db = a\ndb.prod = b\n# db.dev = c\ncache = 1\n# Trailing\n"))
+	require.NoError(t, err)
+
+	keys, variants := Count(settings)
+	assert.Equal(t, 2, keys)
+	assert.Equal(t, 4, variants)
+}
@@ -0,0 +1,458 @@
+package format
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Severity says how likely a Diagnostic is to point at a mistake.
+type Severity int
+
+const (
+	// SeverityWarning is a likely mistake, such as a duplicate key. This is
+	// the severity of every lint.
+	SeverityWarning Severity = iota
+
+	// SeverityInfo is worth knowing but often intended, such as a line the
+	// parser keeps as a comment because it is not a setting.
+	SeverityInfo
+)
+
+var severityNames = map[Severity]string{
+	SeverityWarning: "warning",
+	SeverityInfo:    "info",
+}
+
+func (s Severity) String() string {
+	if name, ok := severityNames[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Diagnostic describes a problem found in parsed settings. Line is 0 for a
+// problem with no line of its own.
+type Diagnostic struct {
+	Line     int
+	Severity Severity
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("line %d: %s: %s", d.Line, d.Severity, d.Message)
+}
+
+// Duplicates reports every variant that repeats the full key of an earlier
+// variant with the same commented status. A commented and an uncommented
+// variant of the same key are not duplicates of each other.
+func Duplicates(settings []*Setting) []Diagnostic {
+	type seenKey struct {
+		key       string
+		commented bool
+	}
+
+	var diagnostics []Diagnostic
+
+	for _, setting := range settings {
+		seen := make(map[seenKey]int)
+
+		for _, variant := range setting.Variants {
+			k := seenKey{variant.Key, variant.Commented}
+
+			first, found := seen[k]
+			if !found {
+				seen[k] = variant.Line
+				continue
+			}
+
+			kind := "key"
+			if variant.Commented {
+				kind = "commented key"
+			}
+
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:    variant.Line,
+				Message: fmt.Sprintf("duplicate %s %q (first seen on line %d)", kind, variant.Key, first),
+			})
+		}
+	}
+
+	sortDiagnostics(diagnostics)
+
+	return diagnostics
+}
+
+// CommentedTwins reports every commented-out variant that has the same key
+// and value as a live variant of its setting and so can be removed. A
+// commented-out variant with a different value is an alternative, not a twin.
+func CommentedTwins(settings []*Setting) []Diagnostic {
+	type keyValue struct {
+		key   string
+		value string
+	}
+
+	var diagnostics []Diagnostic
+
+	for _, setting := range settings {
+		live := make(map[keyValue]int)
+
+		for _, variant := range setting.Variants {
+			k := keyValue{variant.Key, cleanMultiValues(variant.Value)}
+			if _, found := live[k]; !variant.Commented && !found {
+				live[k] = variant.Line
+			}
+		}
+
+		for _, variant := range setting.Variants {
+			if !variant.Commented {
+				continue
+			}
+
+			line, found := live[keyValue{variant.Key, cleanMultiValues(variant.Value)}]
+			if !found {
+				continue
+			}
+
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:    variant.Line,
+				Message: fmt.Sprintf("commented key %q repeats the live value on line %d and can be removed", variant.Key, line),
+			})
+		}
+	}
+
+	sortDiagnostics(diagnostics)
+
+	return diagnostics
+}
+
+// UnbalancedQuotes reports every live variant whose value has an odd number
+// of double quotes, not counting those escaped as \", which usually means a
+// closing quote is missing.
+func UnbalancedQuotes(settings []*Setting) []Diagnostic {
+	var diagnostics []Diagnostic
+
+	for _, setting := range settings {
+		for _, variant := range setting.Variants {
+			if variant.Commented || quoteCount(variant.Value)%2 == 0 {
+				continue
+			}
+
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:    variant.Line,
+				Message: fmt.Sprintf("value of %q has an unbalanced double quote", variant.Key),
+			})
+		}
+	}
+
+	sortDiagnostics(diagnostics)
+
+	return diagnostics
+}
+
+// EmptyValues reports every live variant with an empty value, which usually
+// means a value that was meant to be filled in. Commented-out variants are
+// not reported.
+func EmptyValues(settings []*Setting) []Diagnostic {
+	var diagnostics []Diagnostic
+
+	for _, setting := range settings {
+		for _, variant := range setting.Variants {
+			if variant.Commented || variant.Value != "" {
+				continue
+			}
+
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:    variant.Line,
+				Message: fmt.Sprintf("value of %q is empty", variant.Key),
+			})
+		}
+	}
+
+	sortDiagnostics(diagnostics)
+
+	return diagnostics
+}
+
+// quoteCount returns the number of unescaped double quotes in s.
+func quoteCount(s string) int {
+	n := 0
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			n++
+		}
+	}
+
+	return n
+}
+
+// Lint runs the checks that need no options on settings and returns what
+// they report in line order: duplicate keys, commented-out twins of live
+// variants, unbalanced quotes, empty values and keys with an empty segment.
+func Lint(settings []*Setting) []Diagnostic {
+	diagnostics := Duplicates(settings)
+	diagnostics = append(diagnostics, CommentedTwins(settings)...)
+	diagnostics = append(diagnostics, UnbalancedQuotes(settings)...)
+	diagnostics = append(diagnostics, EmptyValues(settings)...)
+	diagnostics = append(diagnostics, EmptySegments(settings)...)
+
+	sortDiagnostics(diagnostics)
+
+	return diagnostics
+}
+
+// LongLines reports every line of the output of Format, with the padding and
+// wrapping selected by opts, that is more than limit characters long. A
+// variant line is reported with its key, and a line of a comment block with
+// the key it comes before.
+func LongLines(settings []*Setting, opts FormatOptions, limit int) []Diagnostic {
+	var diagnostics []Diagnostic
+
+	marker := commentMarker(opts)
+	width := keyColumn(settings, opts)
+
+	section := ""
+
+	for _, setting := range settings {
+		var block []string
+
+		if setting.Section != section && len(setting.Variants) > 0 {
+			section = setting.Section
+			block = append(block, marker+"["+section+"]")
+		}
+
+		if setting.Comments != "" {
+			for _, line := range strings.Split(strings.ReplaceAll(setting.Comments, "\t", tabSpaces(opts)), "\n") {
+				block = append(block, marker+line)
+			}
+		}
+
+		if longest := longestLine(append(block, setting.Include)...); longest > limit {
+			d := Diagnostic{Message: fmt.Sprintf("comment line is %d characters long, more than %d", longest, limit)}
+
+			if len(setting.Variants) > 0 {
+				d.Line = setting.Variants[0].Line
+				d.Message = fmt.Sprintf("comment line before %q is %d characters long, more than %d", setting.Key, longest, limit)
+			}
+
+			diagnostics = append(diagnostics, d)
+		}
+
+		for i, text := range variantLines(setting, width, marker, opts) {
+			longest := longestLine(strings.Split(text, "\n")...)
+			if longest <= limit {
+				continue
+			}
+
+			variant := setting.Variants[i]
+
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:    variant.Line,
+				Message: fmt.Sprintf("line of %q is %d characters long, more than %d", variant.Key, longest, limit),
+			})
+		}
+	}
+
+	sortDiagnostics(diagnostics)
+
+	return diagnostics
+}
+
+// longestLine returns the length in characters of the longest of lines,
+// ignoring trailing whitespace as Format does.
+func longestLine(lines ...string) int {
+	longest := 0
+	for _, line := range lines {
+		longest = max(longest, utf8.RuneCountInString(strings.TrimRightFunc(line, unicode.IsSpace)))
+	}
+
+	return longest
+}
+
+// MergedCasings reports, once per casing, variants whose root key differs in
+// case from the key of the setting they were grouped into, as happens when
+// parsing with CaseInsensitiveGroup.
+func MergedCasings(settings []*Setting) []Diagnostic {
+	var diagnostics []Diagnostic
+
+	for _, setting := range settings {
+		seen := make(map[string]bool)
+
+		for _, variant := range setting.Variants {
+			root, _ := splitContext(variant.Key)
+			if root == setting.Key || seen[root] {
+				continue
+			}
+
+			seen[root] = true
+
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:    variant.Line,
+				Message: fmt.Sprintf("root key %q merged into %q", root, setting.Key),
+			})
+		}
+	}
+
+	sortDiagnostics(diagnostics)
+
+	return diagnostics
+}
+
+// EmptySegments reports every variant whose key has an empty segment, as
+// in "foo..bar" or "foo.", which usually comes from a stray dot or space.
+// Parse keeps such keys as written, apart from the spaces around each
+// segment, since dropping the segment would change the key gocore reads;
+// InvalidKeys reports them as well.
+func EmptySegments(settings []*Setting) []Diagnostic {
+	var diagnostics []Diagnostic
+
+	for _, setting := range settings {
+		for _, variant := range setting.Variants {
+			if slices.Contains(strings.Split(variant.Key, "."), "") {
+				diagnostics = append(diagnostics, Diagnostic{
+					Line:    variant.Line,
+					Message: fmt.Sprintf("key %q has an empty segment", variant.Key),
+				})
+			}
+		}
+	}
+
+	sortDiagnostics(diagnostics)
+
+	return diagnostics
+}
+
+// InvalidKeys reports every variant whose key breaks the gocore naming
+// rules: a key is one or more segments separated by single dots, and each
+// segment contains only letters, digits and underscores.
+func InvalidKeys(settings []*Setting) []Diagnostic {
+	var diagnostics []Diagnostic
+
+	for _, setting := range settings {
+		for _, variant := range setting.Variants {
+			if problem := keyProblem(variant.Key); problem != "" {
+				diagnostics = append(diagnostics, Diagnostic{
+					Line:    variant.Line,
+					Message: fmt.Sprintf("invalid key %q: %s", variant.Key, problem),
+				})
+			}
+		}
+	}
+
+	sortDiagnostics(diagnostics)
+
+	return diagnostics
+}
+
+// keyProblem describes the first naming rule that key breaks, or returns ""
+// if the key is valid.
+func keyProblem(key string) string {
+	switch {
+	case key == "":
+		return "empty key"
+	case strings.HasPrefix(key, "."):
+		return "leading dot"
+	case strings.HasSuffix(key, "."):
+		return "trailing dot"
+	case strings.Contains(key, ".."):
+		return "empty segment"
+	}
+
+	for _, r := range key {
+		switch {
+		case r == '.' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+		case unicode.IsSpace(r):
+			return "contains a space"
+		default:
+			return fmt.Sprintf("invalid character %q", r)
+		}
+	}
+
+	return ""
+}
+
+func sortDiagnostics(diagnostics []Diagnostic) {
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Line < diagnostics[j].Line
+	})
+}
+
+// minTypoLength is the shortest root key that Typos compares, since short
+// keys such as "db" and "dc" often differ by one character on purpose.
+const minTypoLength = 4
+
+// Typos reports pairs of root keys that are within maxDistance edits of
+// each other, ignoring case, such as timeout and timeut, which are often a
+// typo that gocore would silently ignore. Keys shorter than four characters
+// are not compared. Each pair is reported once, on the first line of the
+// later setting.
+func Typos(settings []*Setting, maxDistance int) []Diagnostic {
+	var diagnostics []Diagnostic
+
+	type root struct {
+		key, folded string
+		line        int
+	}
+
+	var roots []root
+
+	for _, setting := range settings {
+		if len(setting.Variants) == 0 || utf8.RuneCountInString(setting.Key) < minTypoLength {
+			continue
+		}
+
+		folded := strings.ToLower(setting.Key)
+
+		for _, r := range roots {
+			if r.key != setting.Key && editDistance(r.folded, folded) <= maxDistance {
+				diagnostics = append(diagnostics, Diagnostic{
+					Line:    setting.Variants[0].Line,
+					Message: fmt.Sprintf("root key %q is similar to %q on line %d, is it a typo?", setting.Key, r.key, r.line),
+				})
+			}
+		}
+
+		roots = append(roots, root{setting.Key, folded, setting.Variants[0].Line})
+	}
+
+	sortDiagnostics(diagnostics)
+
+	return diagnostics
+}
+
+// editDistance returns the Levenshtein distance between a and b in runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
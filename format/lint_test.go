@@ -0,0 +1,271 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuplicates(t *testing.T) {
+	reader := strings.NewReader(`db.host = a
+db.port = 1
+db.host = a
+# db.host = b
+b = 1
+# db.host = c
+`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	diagnostics := Duplicates(settings)
+
+	assert.Equal(t, []Diagnostic{
+		{Line: 3, Message: `duplicate key "db.host" (first seen on line 1)`},
+		{Line: 6, Message: `duplicate commented key "db.host" (first seen on line 4)`},
+	}, diagnostics)
+}
+
+func TestDuplicatesNone(t *testing.T) {
+	settings, err := Parse(strings.NewReader("a = 1\n# a = 1\na.dev = 2\n"))
+	require.NoError(t, err)
+
+	assert.Empty(t, Duplicates(settings))
+}
+
+func TestCommentedTwins(t *testing.T) {
+	reader := strings.NewReader(`# db.host = live
+db.host = live
+db.port = 1
+# db.port = 2
+# db.user = x
+`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Diagnostic{
+		{Line: 1, Message: `commented key "db.host" repeats the live value on line 2 and can be removed`},
+	}, CommentedTwins(settings))
+}
+
+func TestCommentedTwinsDifferentValue(t *testing.T) {
+	settings, err := Parse(strings.NewReader("db.host = live\n# db.host = old\n"))
+	require.NoError(t, err)
+
+	assert.Empty(t, CommentedTwins(settings))
+}
+
+func TestUnbalancedQuotes(t *testing.T) {
+	reader := strings.NewReader(`name = "foo
+greeting = "say \"hi\"" # fine
+path = C:\\"dir"
+# old = "bar
+`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Diagnostic{
+		{Line: 1, Message: `value of "name" has an unbalanced double quote`},
+	}, UnbalancedQuotes(settings))
+}
+
+func TestEmptyValuesLint(t *testing.T) {
+	reader := strings.NewReader(`db.host =
+db.host.dev = localhost
+# db.host.prod =
+db.user = # fill in
+db.name = ""
+`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Diagnostic{
+		{Line: 1, Message: `value of "db.host" is empty`},
+		{Line: 4, Message: `value of "db.user" is empty`},
+	}, EmptyValues(settings))
+}
+
+func TestLint(t *testing.T) {
+	settings, err := Parse(strings.NewReader("a = 1\nb..c = \"x\na = 1\n# b..c = \"x\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []Diagnostic{
+		{Line: 2, Message: `value of "b..c" has an unbalanced double quote`},
+		{Line: 2, Message: `key "b..c" has an empty segment`},
+		{Line: 3, Message: `duplicate key "a" (first seen on line 1)`},
+		{Line: 4, Message: `commented key "b..c" repeats the live value on line 2 and can be removed`},
+		{Line: 4, Message: `key "b..c" has an empty segment`},
+	}, Lint(settings))
+}
+
+func TestLongLines(t *testing.T) {
+	reader := strings.NewReader(`hosts = alpha.example.com|beta.example.com|gamma.example.com
+hosts.dev = localhost
+short = 1
+`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Diagnostic{
+		{Line: 1, Message: `line of "hosts" is 68 characters long, more than 40`},
+	}, LongLines(settings, FormatOptions{}, 40))
+
+	// The padding from aligning to a long key counts towards the width.
+	assert.Equal(t, []Diagnostic{
+		{Line: 1, Message: `line of "hosts" is 75 characters long, more than 20`},
+		{Line: 2, Message: `line of "hosts.dev" is 28 characters long, more than 20`},
+	}, LongLines(settings, FormatOptions{Width: 16}, 20))
+
+	// Wrapped lines are measured one physical line at a time.
+	assert.Empty(t, LongLines(settings, FormatOptions{WrapWidth: 40}, 40))
+}
+
+func TestLongLinesComments(t *testing.T) {
+	reader := strings.NewReader(`# A comment block line that is far too long
+a = 1
+# A leading comment that is far too long
+a.dev = 2
+#include a/very/long/path/to/another.conf
+# A trailing comment that is too long
+`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Diagnostic{
+		{Message: `comment line is 41 characters long, more than 30`},
+		{Message: `comment line is 37 characters long, more than 30`},
+		{Line: 2, Message: `comment line before "a" is 43 characters long, more than 30`},
+		{Line: 4, Message: `line of "a.dev" is 40 characters long, more than 30`},
+	}, LongLines(settings, FormatOptions{}, 30))
+
+	assert.Empty(t, LongLines(settings, FormatOptions{}, 43))
+}
+
+func TestCaseInsensitiveGroup(t *testing.T) {
+	reader := strings.NewReader(`DB.host = a
+cache = 1
+db.port = 2
+Db.user = 3
+db.host.prod = b
+`)
+
+	settings, err := ParseWithOptions(reader, ParseOptions{CaseInsensitiveGroup: true})
+	require.NoError(t, err)
+
+	require.Equal(t, 2, len(settings))
+	assert.Equal(t, "DB", settings[0].Key)
+
+	keys := make([]string, 0, len(settings[0].Variants))
+	for _, variant := range settings[0].Variants {
+		keys = append(keys, variant.Key)
+	}
+
+	assert.Equal(t, []string{"DB.host", "db.port", "Db.user", "db.host.prod"}, keys)
+
+	assert.Equal(t, []Diagnostic{
+		{Line: 3, Message: `root key "db" merged into "DB"`},
+		{Line: 4, Message: `root key "Db" merged into "DB"`},
+	}, MergedCasings(settings))
+
+	// Without the option the casings stay apart and nothing is reported.
+	settings, err = Parse(strings.NewReader("DB.host = a\ndb.port = 2\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, len(settings))
+	assert.Empty(t, MergedCasings(settings))
+}
+
+func TestInvalidKeys(t *testing.T) {
+	reader := strings.NewReader(`foo..bar = 1
+foo.b@r = 2
+good_key.dev2 = 3
+trailing. = 4
+# bad-key = 5
+Über.host = 6
+`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Diagnostic{
+		{Line: 1, Message: `invalid key "foo..bar": empty segment`},
+		{Line: 2, Message: `invalid key "foo.b@r": invalid character '@'`},
+		{Line: 4, Message: `invalid key "trailing.": trailing dot`},
+		{Line: 5, Message: `invalid key "bad-key": invalid character '-'`},
+	}, InvalidKeys(settings))
+}
+
+func TestInvalidKeysSpace(t *testing.T) {
+	// Parse rejects keys with spaces, but decoded JSON can still hold them.
+	settings := []*Setting{
+		{Key: "foo bar", Variants: []Variant{{Key: "foo bar.baz", Value: "2", Line: 2}}},
+	}
+
+	assert.Equal(t, []Diagnostic{
+		{Line: 2, Message: `invalid key "foo bar.baz": contains a space`},
+	}, InvalidKeys(settings))
+}
+
+func TestTypos(t *testing.T) {
+	reader := strings.NewReader(`timeout = 30
+retries = 3
+timeut = 60
+timeOut.dev = 10
+db = 1
+dc = 2
+# retires = 4
+`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Diagnostic{
+		{Line: 3, Message: `root key "timeut" is similar to "timeout" on line 1, is it a typo?`},
+		{Line: 4, Message: `root key "timeOut" is similar to "timeout" on line 1, is it a typo?`},
+		{Line: 4, Message: `root key "timeOut" is similar to "timeut" on line 3, is it a typo?`},
+	}, Typos(settings, 1))
+
+	assert.Len(t, Typos(settings, 2), 4)
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, test := range []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"timeout", "timeut", 1},
+		{"kitten", "sitting", 3},
+		{"über", "uber", 1},
+	} {
+		assert.Equal(t, test.distance, editDistance(test.a, test.b), "%s %s", test.a, test.b)
+	}
+}
+
+func TestEmptySegments(t *testing.T) {
+	reader := strings.NewReader(`foo. .bar = 1
+foo..bar = 2
+foo.bar = 3
+# trailing. = 4
+`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	keys := variantKeys(settings[0].Variants)
+	assert.Equal(t, []string{"foo..bar", "foo..bar", "foo.bar"}, keys)
+
+	assert.Equal(t, []Diagnostic{
+		{Line: 1, Message: `key "foo..bar" has an empty segment`},
+		{Line: 2, Message: `key "foo..bar" has an empty segment`},
+		{Line: 4, Message: `key "trailing." has an empty segment`},
+	}, EmptySegments(settings))
+}
@@ -0,0 +1,209 @@
+package format
+
+import (
+	"fmt"
+	"slices"
+)
+
+// MergeStrategy selects what Merge does when a later source sets a live
+// variant to a different value than an earlier one.
+type MergeStrategy int
+
+const (
+	// MergeLast keeps the later value. This is the default.
+	MergeLast MergeStrategy = iota
+
+	// MergeFirst keeps the earlier value.
+	MergeFirst
+
+	// MergeError makes the merge fail.
+	MergeError
+
+	// MergeKeepBoth keeps the later value and the earlier one commented out
+	// before it, with a note naming the source that overrode it.
+	MergeKeepBoth
+)
+
+var mergeStrategyNames = map[MergeStrategy]string{
+	MergeLast:     "last",
+	MergeFirst:    "first",
+	MergeError:    "error",
+	MergeKeepBoth: "keep-both",
+}
+
+func (s MergeStrategy) String() string {
+	if name, ok := mergeStrategyNames[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("MergeStrategy(%d)", int(s))
+}
+
+// Set parses a strategy name, so that a MergeStrategy can be used as a
+// flag.Value.
+func (s *MergeStrategy) Set(name string) error {
+	for strategy, n := range mergeStrategyNames {
+		if n == name {
+			*s = strategy
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown merge strategy %q", name)
+}
+
+// MergeOptions controls how sources are merged.
+type MergeOptions struct {
+	Strategy MergeStrategy
+}
+
+// Source is a named set of settings, such as one parsed file, to be merged.
+type Source struct {
+	Name     string
+	Settings []*Setting
+}
+
+// Override records a live variant that set a different value for the same
+// full key as one from an earlier source during Merge. With MergeFirst, New
+// is the variant that was dropped.
+type Override struct {
+	Key       string
+	Old       Variant
+	OldSource string
+	New       Variant
+	NewSource string
+}
+
+// Merge combines sources in order into one set of settings grouped by root
+// key. A live variant replaces an earlier live variant with the same full
+// key, so later sources win; a commented variant is added unless an
+// identical one is already present. Comment blocks from each source are
+// concatenated, skipping repeats, and a merged setting is in the section of
+// the first source that has it. Include directives are kept in order.
+// The inputs are not modified.
+func Merge(sources ...Source) ([]*Setting, []Override) {
+	merged, overrides, _ := MergeWithOptions(sources, MergeOptions{})
+
+	return merged, overrides
+}
+
+// MergeWithOptions merges sources as Merge does, resolving a live variant
+// that sets a different value than one from an earlier source as selected by
+// opts. A variant repeated within one source replaces the earlier one
+// whatever the strategy. The error, for MergeError, names both sources.
+func MergeWithOptions(sources []Source, opts MergeOptions) ([]*Setting, []Override, error) {
+	var (
+		merged    []*Setting
+		overrides []Override
+	)
+
+	byKey := make(map[string]*Setting)
+	origin := make(map[string]string)
+
+	for _, source := range sources {
+		for _, setting := range source.Settings {
+			if setting.Include != "" {
+				directive := *setting
+				merged = append(merged, &directive)
+
+				continue
+			}
+
+			m, found := byKey[setting.Key]
+			if !found {
+				m = &Setting{Key: setting.Key, Section: setting.Section}
+				byKey[setting.Key] = m
+				merged = append(merged, m)
+			}
+
+			m.Comments = joinComments(m.Comments, setting.Comments)
+
+			for _, variant := range setting.Variants {
+				i := indexVariant(m.Variants, variant)
+				if i < 0 {
+					m.Variants = append(m.Variants, variant)
+
+					if !variant.Commented {
+						origin[variant.Key] = source.Name
+					}
+
+					continue
+				}
+
+				if variant.Commented {
+					continue
+				}
+
+				old := m.Variants[i]
+
+				if old.Value == variant.Value || origin[variant.Key] == source.Name {
+					m.Variants[i] = variant
+					origin[variant.Key] = source.Name
+
+					continue
+				}
+
+				override := Override{
+					Key:       variant.Key,
+					Old:       old,
+					OldSource: origin[variant.Key],
+					New:       variant,
+					NewSource: source.Name,
+				}
+
+				switch opts.Strategy {
+				case MergeFirst:
+					overrides = append(overrides, override)
+					continue
+				case MergeError:
+					return nil, nil, fmt.Errorf("%q is %q in %s:%d but %q in %s:%d", variant.Key, old.Value, override.OldSource, old.Line, variant.Value, source.Name, variant.Line)
+				case MergeKeepBoth:
+					note := "overridden by " + source.Name
+					if old.Comment != "" {
+						note = old.Comment + "; " + note
+					}
+
+					m.Variants[i].Commented = true
+					m.Variants[i].Comment = note
+					m.Variants = slices.Insert(m.Variants, i+1, variant)
+				default:
+					m.Variants[i] = variant
+				}
+
+				overrides = append(overrides, override)
+				origin[variant.Key] = source.Name
+			}
+		}
+	}
+
+	return merged, overrides, nil
+}
+
+// indexVariant returns the index of the variant in variants that v merges
+// with: the live variant with the same key if v is live, or an identical
+// commented variant if v is commented. It returns -1 if there is none.
+func indexVariant(variants []Variant, v Variant) int {
+	for i, existing := range variants {
+		if existing.Key != v.Key || existing.Commented != v.Commented {
+			continue
+		}
+
+		if !v.Commented || existing.Value == v.Value {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// joinComments appends comment block b to a unless it is empty or the same.
+func joinComments(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "" || a == b:
+		return a
+	default:
+		return a + "\n" + b
+	}
+}
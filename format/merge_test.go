@@ -0,0 +1,171 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseSource(t *testing.T, name, input string) Source {
+	t.Helper()
+
+	settings, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+
+	return Source{Name: name, Settings: settings}
+}
+
+func TestMergeOverride(t *testing.T) {
+	a := parseSource(t, "a.conf", `# Database
+db.host = a
+db.port = 1
+# db.user = old
+`)
+	b := parseSource(t, "b.conf", `db.host = b
+db.host.prod = p
+# db.user = old
+db.port = 1
+`)
+
+	merged, overrides := Merge(a, b)
+
+	Sort(merged)
+
+	buf := &bytes.Buffer{}
+	err := Format(buf, merged)
+	require.NoError(t, err)
+
+	assert.Equal(t, `# Database
+db.host      = b
+db.port      = 1
+# db.user    = old
+db.host.prod = p
+
+`, buf.String())
+
+	require.Equal(t, 1, len(overrides))
+	assert.Equal(t, "db.host", overrides[0].Key)
+	assert.Equal(t, "a", overrides[0].Old.Value)
+	assert.Equal(t, "a.conf", overrides[0].OldSource)
+	assert.Equal(t, 2, overrides[0].Old.Line)
+	assert.Equal(t, "b", overrides[0].New.Value)
+	assert.Equal(t, "b.conf", overrides[0].NewSource)
+	assert.Equal(t, 1, overrides[0].New.Line)
+
+	// The inputs are left untouched.
+	assert.Equal(t, "a", a.Settings[0].Variants[0].Value)
+}
+
+func TestMergeDisjoint(t *testing.T) {
+	a := parseSource(t, "a.conf", `# About b
+b = 2
+`)
+	b := parseSource(t, "b.conf", `# About a
+a = 1
+c = 3
+`)
+
+	merged, overrides := Merge(a, b)
+	assert.Empty(t, overrides)
+
+	Sort(merged)
+
+	buf := &bytes.Buffer{}
+	err := Format(buf, merged)
+	require.NoError(t, err)
+
+	assert.Equal(t, `# About a
+a = 1
+
+# About b
+b = 2
+
+c = 3
+
+`, buf.String())
+}
+
+func TestMergeStrategies(t *testing.T) {
+	a := parseSource(t, "a.conf", "db.host = a # local\ndb.port = 1\n")
+	b := parseSource(t, "b.conf", "db.host = b\ndb.port = 1\n")
+
+	tests := []struct {
+		strategy MergeStrategy
+		want     string
+		err      string
+	}{
+		{
+			strategy: MergeLast,
+			want:     "db.host = b\ndb.port = 1\n\n",
+		},
+		{
+			strategy: MergeFirst,
+			want:     "db.host = a # local\ndb.port = 1\n\n",
+		},
+		{
+			strategy: MergeError,
+			err:      `"db.host" is "a" in a.conf:1 but "b" in b.conf:1`,
+		},
+		{
+			strategy: MergeKeepBoth,
+			want:     "# db.host = a # local; overridden by b.conf\ndb.host   = b\ndb.port   = 1\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy.String(), func(t *testing.T) {
+			merged, overrides, err := MergeWithOptions([]Source{a, b}, MergeOptions{Strategy: tt.strategy})
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+
+			require.NoError(t, err)
+
+			require.Len(t, overrides, 1)
+			assert.Equal(t, "a", overrides[0].Old.Value)
+			assert.Equal(t, "b", overrides[0].New.Value)
+
+			buf := &bytes.Buffer{}
+			require.NoError(t, Format(buf, merged))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+
+	// The inputs are left untouched.
+	assert.False(t, a.Settings[0].Variants[0].Commented)
+	assert.Equal(t, "local", a.Settings[0].Variants[0].Comment)
+}
+
+func TestMergeStrategySet(t *testing.T) {
+	var s MergeStrategy
+
+	require.NoError(t, s.Set("keep-both"))
+	assert.Equal(t, MergeKeepBoth, s)
+
+	assert.EqualError(t, s.Set("newest"), `unknown merge strategy "newest"`)
+}
+
+func TestMergeSections(t *testing.T) {
+	parse := func(name, input string) Source {
+		settings, err := ParseWithOptions(strings.NewReader(input), ParseOptions{Sections: true})
+		require.NoError(t, err)
+
+		return Source{Name: name, Settings: settings}
+	}
+
+	a := parse("a.conf", "# [db]\n\n# The host\ndb.host = a\n\n# [cache]\n\ncache.ttl = 1\n")
+	b := parse("b.conf", "db.host = b\nextra = 1\n")
+
+	merged, _ := Merge(a, b)
+
+	SortWithOptions(merged, SortOptions{BySection: true})
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, merged))
+
+	assert.Equal(t, "extra = 1\n\n# [db]\n\n# The host\ndb.host = b\n\n# [cache]\n\ncache.ttl = 1\n\n", buf.String())
+}
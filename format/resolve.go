@@ -0,0 +1,49 @@
+package format
+
+import "strings"
+
+// Resolve returns the value that key takes in context, as gocore resolves
+// it: the variant for the full context if there is one, otherwise the one
+// for the context with its last segment removed, and so on down to the base
+// key. For key "db" and context "dev.local" it tries db.dev.local, db.dev
+// and db in turn. Commented variants are ignored and, where a key is set
+// more than once, the last value wins. ok is false if no variant applies,
+// as for a key with only a prod variant resolved in dev; Context omits such
+// a key in the same way.
+func (s Settings) Resolve(key, context string) (value string, ok bool) {
+	for _, c := range contextChain(context) {
+		if value, ok := s.lookup(fullKey(key, c)); ok {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// contextChain returns the contexts that context falls back through, most
+// specific first and ending with the base, "".
+func contextChain(context string) []string {
+	var chain []string
+
+	for context != "" {
+		chain = append(chain, context)
+
+		i := strings.LastIndexByte(context, '.')
+		context = context[:max(i, 0)]
+	}
+
+	return append(chain, "")
+}
+
+// lookup returns the last live value of the variant with exactly key.
+func (s Settings) lookup(key string) (value string, ok bool) {
+	for _, setting := range s {
+		for _, variant := range setting.Variants {
+			if !variant.Commented && variant.Key == key {
+				value, ok = variant.Value, true
+			}
+		}
+	}
+
+	return value, ok
+}
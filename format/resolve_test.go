@@ -0,0 +1,51 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	parsed, err := Parse(strings.NewReader(`db = base
+db.dev = dev
+db.dev.local = local
+# db.prod = commented
+port.prod = 443
+`))
+	require.NoError(t, err)
+
+	settings := Settings(parsed)
+
+	tests := []struct {
+		key, context string
+		value        string
+		ok           bool
+	}{
+		{"db", "dev", "dev", true},
+		{"db", "dev.local", "local", true},
+		{"db", "dev.remote", "dev", true},
+		{"db", "", "base", true},
+		{"db", "prod", "base", true},
+		{"port", "prod", "443", true},
+		{"port", "dev", "", false},
+		{"missing", "dev", "", false},
+	}
+
+	for _, test := range tests {
+		value, ok := settings.Resolve(test.key, test.context)
+		assert.Equal(t, test.ok, ok, "%s in %q", test.key, test.context)
+		assert.Equal(t, test.value, value, "%s in %q", test.key, test.context)
+	}
+}
+
+func TestResolveLastWins(t *testing.T) {
+	parsed, err := Parse(strings.NewReader("a = 1\na = 2\n"))
+	require.NoError(t, err)
+
+	value, ok := Settings(parsed).Resolve("a", "")
+	assert.True(t, ok)
+	assert.Equal(t, "2", value)
+}
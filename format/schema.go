@@ -0,0 +1,136 @@
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Schema declares the root keys a config may contain. It is read from JSON
+// such as
+//
+//	{"keys": {"port": {"type": "int", "required": true}, "debug": {"type": "bool"}}}
+type Schema struct {
+	Keys map[string]KeySchema `json:"keys"`
+}
+
+// KeySchema describes one root key. Type is one of those named by
+// SchemaTypes: string, int, bool, duration or url. It applies to the base key
+// and every context; an empty Type allows any value.
+type KeySchema struct {
+	Type     string `json:"type,omitempty"`
+	Required bool   `json:"required,omitempty"`
+}
+
+// schemaTypes maps each type a KeySchema may name to the check for its
+// values.
+var schemaTypes = map[string]func(string) bool{
+	"string": func(string) bool { return true },
+	"int": func(s string) bool {
+		_, err := strconv.ParseInt(s, 10, 64)
+		return err == nil
+	},
+	"bool": func(s string) bool {
+		_, ok := parseBool(s, true)
+		return ok
+	},
+	"duration": func(s string) bool {
+		_, err := time.ParseDuration(s)
+		return err == nil
+	},
+	"url": func(s string) bool {
+		u, err := url.Parse(s)
+		return err == nil && u.Scheme != "" && u.Host != ""
+	},
+}
+
+// SchemaTypes returns the types a KeySchema may name, sorted.
+func SchemaTypes() []string {
+	types := make([]string, 0, len(schemaTypes))
+	for name := range schemaTypes {
+		types = append(types, name)
+	}
+
+	sort.Strings(types)
+
+	return types
+}
+
+// DecodeSchema reads a Schema from JSON.
+func DecodeSchema(r io.Reader) (*Schema, error) {
+	var schema Schema
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&schema); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	for key, k := range schema.Keys {
+		if _, ok := schemaTypes[k.Type]; k.Type != "" && !ok {
+			return nil, fmt.Errorf("invalid schema: key %q has unknown type %q", key, k.Type)
+		}
+	}
+
+	return &schema, nil
+}
+
+// Validate reports live variants whose root key the schema does not declare
+// or whose value does not have the declared type, and required root keys
+// with no live variant. Missing keys are reported on line 0.
+func (s *Schema) Validate(settings []*Setting) []Diagnostic {
+	var diagnostics []Diagnostic
+
+	present := make(map[string]bool)
+
+	for _, setting := range settings {
+		k, known := s.Keys[setting.Key]
+
+		for _, variant := range setting.Variants {
+			if variant.Commented {
+				continue
+			}
+
+			if !known {
+				if !present[setting.Key] {
+					diagnostics = append(diagnostics, Diagnostic{
+						Line:    variant.Line,
+						Message: fmt.Sprintf("unknown key %q", setting.Key),
+					})
+				}
+			} else if k.Type != "" && !schemaTypes[k.Type](variant.Value) {
+				diagnostics = append(diagnostics, Diagnostic{
+					Line:    variant.Line,
+					Message: fmt.Sprintf("value %q of %q is not a valid %s", variant.Value, variant.Key, k.Type),
+				})
+			}
+
+			present[setting.Key] = true
+		}
+	}
+
+	var missing []string
+
+	for key, k := range s.Keys {
+		if k.Required && !present[key] {
+			missing = append(missing, key)
+		}
+	}
+
+	sort.Strings(missing)
+
+	for _, key := range missing {
+		diagnostics = append(diagnostics, Diagnostic{
+			Message: fmt.Sprintf("missing required key %q", key),
+		})
+	}
+
+	sortDiagnostics(diagnostics)
+
+	return diagnostics
+}
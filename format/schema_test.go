@@ -0,0 +1,64 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := DecodeSchema(strings.NewReader(`{
+		"keys": {
+			"port":    {"type": "int", "required": true},
+			"debug":   {"type": "bool"},
+			"timeout": {"type": "duration"},
+			"api":     {"type": "url", "required": true},
+			"name":    {}
+		}
+	}`))
+	require.NoError(t, err)
+
+	settings, err := Parse(strings.NewReader(`port = 80
+port.dev = eighty
+debug = true
+timeout = 5s
+timeout.prod = 5
+colour = blue
+colour.dev = red
+# api = http://example.com
+name = anything
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []Diagnostic{
+		{Line: 0, Message: `missing required key "api"`},
+		{Line: 2, Message: `value "eighty" of "port.dev" is not a valid int`},
+		{Line: 5, Message: `value "5" of "timeout.prod" is not a valid duration`},
+		{Line: 6, Message: `unknown key "colour"`},
+	}, schema.Validate(settings))
+}
+
+func TestDecodeSchemaErrors(t *testing.T) {
+	_, err := DecodeSchema(strings.NewReader(`{"keys": {"port": {"type": "integer"}}}`))
+	assert.EqualError(t, err, `invalid schema: key "port" has unknown type "integer"`)
+
+	_, err = DecodeSchema(strings.NewReader(`{"keys": {"port": {"kind": "int"}}}`))
+	assert.ErrorContains(t, err, "invalid schema: ")
+}
+
+func TestSchemaBoolForms(t *testing.T) {
+	schema := &Schema{Keys: map[string]KeySchema{"debug": {Type: "bool"}}}
+
+	settings, err := Parse(strings.NewReader("debug = yes\ndebug.dev = 1\ndebug.prod = maybe\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []Diagnostic{
+		{Line: 3, Message: `value "maybe" of "debug.prod" is not a valid bool`},
+	}, schema.Validate(settings))
+}
+
+func TestSchemaTypes(t *testing.T) {
+	assert.Equal(t, []string{"bool", "duration", "int", "string", "url"}, SchemaTypes())
+}
@@ -0,0 +1,69 @@
+package format
+
+import (
+	"bytes"
+)
+
+// Select parses src and separates the settings whose root key match selects
+// from the rest of its text, so that they can be rewritten in place while
+// every other line is kept byte for byte. It returns the selected settings,
+// with their comment blocks and the blank lines after them, and the
+// remaining lines of src before and after the first line of a selected
+// setting. Include directives are not followed.
+func Select(src []byte, opts ParseOptions, match func(key string) bool) (selected []*Setting, before, after []byte, err error) {
+	opts.Includes = false
+
+	p, err := parseAll(bytes.NewReader(src), opts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	owned := make(map[int]bool)
+
+	for _, setting := range p.order {
+		if len(setting.Variants) == 0 || !match(setting.Key) {
+			continue
+		}
+
+		selected = append(selected, setting)
+
+		for _, line := range p.lines[setting] {
+			owned[line] = true
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, src, nil, nil
+	}
+
+	var rest bytes.Buffer
+
+	split := -1
+
+	lines := bytes.SplitAfter(src, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	for i, line := range lines {
+		// The blank lines after a selected setting go with it, as the
+		// formatter writes its own.
+		if !owned[i+1] && i > 0 && owned[i] && len(bytes.TrimSpace(line)) == 0 {
+			owned[i+1] = true
+		}
+
+		if owned[i+1] {
+			if split < 0 {
+				split = rest.Len()
+			}
+
+			continue
+		}
+
+		rest.Write(line)
+	}
+
+	b := rest.Bytes()
+
+	return selected, b[:split:split], b[split:], nil
+}
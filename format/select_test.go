@@ -0,0 +1,45 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelect(t *testing.T) {
+	src := `cache.ttl   =   60
+# Database
+db.port=5432
+cache.size = 10   # kept verbatim
+
+db.host = x|y \
+  |z
+# trailing
+`
+
+	selected, before, after, err := Select([]byte(src), ParseOptions{}, func(key string) bool {
+		return strings.HasPrefix(key, "db")
+	})
+	require.NoError(t, err)
+
+	require.Len(t, selected, 1)
+	assert.Equal(t, "db", selected[0].Key)
+	assert.Equal(t, "Database", selected[0].Comments)
+	assert.Len(t, selected[0].Variants, 2)
+
+	assert.Equal(t, "cache.ttl   =   60\n", string(before))
+	assert.Equal(t, "cache.size = 10   # kept verbatim\n\n# trailing\n", string(after))
+}
+
+func TestSelectNone(t *testing.T) {
+	src := "a = 1\n"
+
+	selected, before, after, err := Select([]byte(src), ParseOptions{}, func(string) bool { return false })
+	require.NoError(t, err)
+
+	assert.Empty(t, selected)
+	assert.Equal(t, src, string(before))
+	assert.Empty(t, after)
+}
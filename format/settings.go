@@ -0,0 +1,163 @@
+package format
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Settings is a parsed settings file. Its methods read and edit variants by
+// root key and context, the part of a variant key after the root key, with
+// an empty context meaning the base key.
+type Settings []*Setting
+
+// Get returns the value of the live variant for key in context, without
+// falling back to other contexts as Resolve does. If the variant is
+// repeated the last value wins.
+func (s Settings) Get(key, context string) (value string, ok bool) {
+	return s.lookup(fullKey(key, context))
+}
+
+// Upsert sets the value of key in context. It updates the last live variant
+// with that key or, if there is none, adds one to the setting for the root
+// key of key, adding the setting at the end if there is none. A non-empty
+// comment replaces the variant's inline comment.
+func (s *Settings) Upsert(key, context, value, comment string) {
+	full := fullKey(key, context)
+	root, _, _ := strings.Cut(full, ".")
+
+	var setting *Setting
+
+	for _, candidate := range *s {
+		if candidate.Key == root && len(candidate.Variants) > 0 {
+			setting = candidate
+			break
+		}
+	}
+
+	if setting == nil {
+		setting = &Setting{Key: root}
+		*s = append(*s, setting)
+	}
+
+	for i := len(setting.Variants) - 1; i >= 0; i-- {
+		if variant := &setting.Variants[i]; !variant.Commented && variant.Key == full {
+			variant.Value = value
+			if comment != "" {
+				variant.Comment = comment
+			}
+
+			return
+		}
+	}
+
+	setting.Variants = append(setting.Variants, Variant{Key: full, Value: value, Comment: comment})
+}
+
+// Delete removes every variant, live or commented out, for key in context
+// and reports whether there were any. A setting left without variants is
+// removed along with its comment block.
+func (s *Settings) Delete(key, context string) bool {
+	full := fullKey(key, context)
+	deleted := false
+
+	kept := (*s)[:0]
+
+	for _, setting := range *s {
+		if len(setting.Variants) == 0 {
+			kept = append(kept, setting)
+			continue
+		}
+
+		variants := setting.Variants[:0]
+		for _, variant := range setting.Variants {
+			if variant.Key == full {
+				deleted = true
+			} else {
+				variants = append(variants, variant)
+			}
+		}
+
+		setting.Variants = variants
+
+		if len(variants) > 0 {
+			kept = append(kept, setting)
+		}
+	}
+
+	clear((*s)[len(kept):])
+	*s = kept
+
+	return deleted
+}
+
+// SetCommented comments out every variant for key in context, or if
+// commented is false makes one of them live, and reports whether there were
+// any. Only the last commented-out variant is uncommented, and none is if a
+// variant is live already, so that the key is never left set twice.
+func (s Settings) SetCommented(key, context string, commented bool) bool {
+	full := fullKey(key, context)
+
+	var (
+		found bool
+		last  *Variant
+	)
+
+	for _, setting := range s {
+		for i := range setting.Variants {
+			variant := &setting.Variants[i]
+			if variant.Key != full {
+				continue
+			}
+
+			found = true
+
+			switch {
+			case commented:
+				variant.Commented = true
+			case !variant.Commented:
+				return true
+			default:
+				last = variant
+			}
+		}
+	}
+
+	if last != nil {
+		last.Commented = false
+	}
+
+	return found
+}
+
+// ParseVariant reads a single key=value line, with an optional inline
+// comment, as a live variant, the way a line of a file is read under opts.
+func ParseVariant(line string, opts ParseOptions) (Variant, error) {
+	line = strings.TrimSpace(line)
+
+	c := commentChar(opts.CommentChar)
+	if line == "" || line[0] == c {
+		return Variant{}, fmt.Errorf("%q is not a key=value pair", line)
+	}
+
+	item := processLine(line, c, opts.Separator, opts.RawValues)
+
+	switch {
+	case item == nil:
+		return Variant{}, fmt.Errorf("%q is not a key=value pair", line)
+	case strings.Split(item.Key, ".")[0] == "":
+		return Variant{}, fmt.Errorf("empty key in %q", line)
+	case hasSpace(item.Key):
+		return Variant{}, fmt.Errorf("key %q contains whitespace", item.Key)
+	}
+
+	return *item, nil
+}
+
+// fullKey returns the variant key of key in context.
+func fullKey(key, context string) string {
+	if context == "" {
+		return key
+	}
+
+	return key + "." + context
+}
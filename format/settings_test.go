@@ -0,0 +1,138 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettingsEdit(t *testing.T) {
+	parsed, err := Parse(strings.NewReader(`# The database
+db.host = localhost
+# db.host.prod = old
+db.port = 5432
+cache = on
+`))
+	require.NoError(t, err)
+
+	settings := Settings(parsed)
+
+	// Update an existing variant, keeping its position.
+	settings.Upsert("db.host", "", "db.local", "")
+
+	// Add a variant to an existing setting and a new setting.
+	settings.Upsert("db.host", "prod", "db.example.com", "primary")
+	settings.Upsert("timeout", "dev", "300", "")
+
+	value, ok := settings.Get("db.host", "prod")
+	assert.True(t, ok)
+	assert.Equal(t, "db.example.com", value)
+
+	_, ok = settings.Get("timeout", "")
+	assert.False(t, ok)
+
+	// Deleting removes commented-out variants too, then empty settings.
+	assert.True(t, settings.Delete("db.host", "prod"))
+	assert.True(t, settings.Delete("cache", ""))
+	assert.False(t, settings.Delete("cache", ""))
+
+	_, ok = settings.Get("cache", "")
+	assert.False(t, ok)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+
+	assert.Equal(t, `# The database
+db.host = db.local
+db.port = 5432
+
+timeout.dev = 300
+
+`, buf.String())
+}
+
+func TestSettingsUpsertEmpty(t *testing.T) {
+	var settings Settings
+
+	settings.Upsert("a", "", "1", "first")
+	settings.Upsert("a", "", "2", "")
+
+	require.Len(t, settings, 1)
+	assert.Equal(t, []Variant{{Key: "a", Value: "2", Comment: "first"}}, settings[0].Variants)
+}
+
+func TestParseVariant(t *testing.T) {
+	variant, err := ParseVariant(" db.host.prod = example.com # primary ", ParseOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, Variant{Key: "db.host.prod", Value: "example.com", Comment: "primary"}, variant)
+
+	variant, err = ParseVariant("port: 80", ParseOptions{Separator: ':'})
+	require.NoError(t, err)
+	assert.Equal(t, Variant{Key: "port", Value: "80"}, variant)
+
+	tests := map[string]string{
+		"db.host":   `"db.host" is not a key=value pair`,
+		"# a=1":     `"# a=1" is not a key=value pair`,
+		".host=1":   `empty key in ".host=1"`,
+		"db host=1": `key "db host" contains whitespace`,
+		"":          `"" is not a key=value pair`,
+	}
+
+	for line, want := range tests {
+		_, err := ParseVariant(line, ParseOptions{})
+		assert.EqualError(t, err, want, line)
+	}
+}
+
+func TestSettingsSetCommented(t *testing.T) {
+	parsed, err := Parse(strings.NewReader("feature.x = on\nfeature.x.dev = off\n# feature.y = on\n"))
+	require.NoError(t, err)
+
+	settings := Settings(parsed)
+
+	assert.True(t, settings.SetCommented("feature.x", "", true))
+	assert.True(t, settings.SetCommented("feature", "y", false))
+	assert.False(t, settings.SetCommented("feature.z", "", true))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+
+	assert.Equal(t, "# feature.x   = on\nfeature.x.dev = off\nfeature.y     = on\n\n", buf.String())
+}
+
+func TestSettingsSetCommentedOnce(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "last commented",
+			input:    "# a = 1\n# a = 2\n",
+			expected: "# a = 1\na   = 2\n\n",
+		},
+		{
+			name:     "already live",
+			input:    "# a = 1\na = 2\n",
+			expected: "# a = 1\na   = 2\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := Parse(strings.NewReader(tt.input))
+			require.NoError(t, err)
+
+			settings := Settings(parsed)
+			assert.True(t, settings.SetCommented("a", "", false))
+
+			buf := &bytes.Buffer{}
+			require.NoError(t, Format(buf, settings))
+
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+}
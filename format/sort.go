@@ -0,0 +1,228 @@
+package format
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SortStrategy selects how Sort orders settings by root key.
+type SortStrategy int
+
+const (
+	// SortUpperFirst puts keys starting with an uppercase letter before all
+	// others, each group in byte order. This is the default.
+	SortUpperFirst SortStrategy = iota
+
+	// SortAlpha orders keys case-insensitively so that related keys such as
+	// Server and server stay together. Keys equal apart from case are in
+	// byte order.
+	SortAlpha
+
+	// SortLength orders keys by their length in characters, shortest
+	// first, and keys of the same length in byte order.
+	SortLength
+)
+
+var sortStrategyNames = map[SortStrategy]string{
+	SortUpperFirst: "upperfirst",
+	SortAlpha:      "alpha",
+	SortLength:     "length",
+}
+
+func (s SortStrategy) String() string {
+	if name, ok := sortStrategyNames[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("SortStrategy(%d)", int(s))
+}
+
+// Set parses a strategy name, so that a SortStrategy can be used as a
+// flag.Value.
+func (s *SortStrategy) Set(name string) error {
+	for strategy, n := range sortStrategyNames {
+		if n == name {
+			*s = strategy
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown sort strategy %q", name)
+}
+
+// DefaultContextOrder is a conventional precedence for SortOptions.ContextOrder.
+var DefaultContextOrder = []string{"dev", "test", "staging", "prod"}
+
+// SortOptions controls how settings are ordered.
+type SortOptions struct {
+	Strategy SortStrategy
+
+	// SortVariants also reorders the variants within each setting: the
+	// base key comes first, then contexts in the order given by
+	// ContextOrder, then any other contexts alphabetically. A context is
+	// the part of a variant key after the root key, and is matched against
+	// ContextOrder by its first segment. Variants with the same key keep
+	// their input order.
+	SortVariants bool
+	ContextOrder []string
+
+	// LiveFirst moves the commented-out variants of each setting after its
+	// live ones, keeping the order within each group. With SortVariants,
+	// each group is ordered by context.
+	LiveFirst bool
+
+	// BySection keeps settings grouped by Section, with settings outside
+	// any section first and sections in the order they first appear, and
+	// sorts within each group.
+	BySection bool
+}
+
+// Sort orders settings by root key, with uppercase keys first. Settings
+// without variants, such as a trailing comment block, are kept at the end.
+func Sort(settings []*Setting) {
+	SortWithOptions(settings, SortOptions{})
+}
+
+// SortWithOptions orders settings as controlled by opts. Settings without
+// variants are kept at the end and an empty key sorts before any other.
+// Include directives split the settings into runs sorted on their own. The
+// sort is stable.
+func SortWithOptions(settings []*Setting, opts SortOptions) {
+	less := lessUpperFirst
+	switch opts.Strategy {
+	case SortAlpha:
+		less = lessAlpha
+	case SortLength:
+		less = lessLength
+	}
+
+	OrderVariants(settings, opts)
+
+	sections := make(map[string]int)
+	if opts.BySection {
+		for _, setting := range settings {
+			if _, found := sections[setting.Section]; !found && setting.Section != "" {
+				sections[setting.Section] = len(sections) + 1
+			}
+		}
+	}
+
+	// Settings with equal keys, as after Merge or when decoded from JSON,
+	// keep their input order so the output does not vary between runs.
+	sortRun := func(settings []*Setting) {
+		sort.SliceStable(settings, func(i, j int) bool {
+			if len(settings[i].Variants) == 0 || len(settings[j].Variants) == 0 {
+				return len(settings[i].Variants) != 0
+			}
+
+			if si, sj := sections[settings[i].Section], sections[settings[j].Section]; si != sj {
+				return si < sj
+			}
+
+			if settings[i].Key == "" || settings[j].Key == "" {
+				return settings[i].Key == "" && settings[j].Key != ""
+			}
+
+			return less(settings[i].Key, settings[j].Key)
+		})
+	}
+
+	// An include directive stays where it is, and the settings on each side
+	// of it are sorted apart, so that each keeps the values it overrides or
+	// is overridden by.
+	start := 0
+
+	for i, setting := range settings {
+		if setting.Include != "" {
+			sortRun(settings[start:i])
+			start = i + 1
+		}
+	}
+
+	sortRun(settings[start:])
+}
+
+// OrderVariants orders the variants within each setting as SortVariants and
+// LiveFirst in opts ask, leaving the settings themselves in order. Use it to
+// order variants without sorting settings; SortWithOptions calls it.
+func OrderVariants(settings []*Setting, opts SortOptions) {
+	if opts.SortVariants {
+		for _, setting := range settings {
+			sortVariants(setting.Variants, opts.ContextOrder)
+		}
+	}
+
+	if opts.LiveFirst {
+		for _, setting := range settings {
+			sort.SliceStable(setting.Variants, func(i, j int) bool {
+				return !setting.Variants[i].Commented && setting.Variants[j].Commented
+			})
+		}
+	}
+}
+
+func lessUpperFirst(a, b string) bool {
+	r1, _ := utf8.DecodeRuneInString(a)
+	r2, _ := utf8.DecodeRuneInString(b)
+	if unicode.IsUpper(r1) != unicode.IsUpper(r2) {
+		return unicode.IsUpper(r1)
+	}
+
+	return a < b
+}
+
+func lessLength(a, b string) bool {
+	if la, lb := utf8.RuneCountInString(a), utf8.RuneCountInString(b); la != lb {
+		return la < lb
+	}
+
+	return a < b
+}
+
+func lessAlpha(a, b string) bool {
+	la, lb := strings.ToLower(a), strings.ToLower(b)
+	if la != lb {
+		return la < lb
+	}
+
+	return a < b
+}
+
+func sortVariants(variants []Variant, contextOrder []string) {
+	rank := make(map[string]int, len(contextOrder))
+	for i, context := range contextOrder {
+		if _, found := rank[context]; !found {
+			rank[context] = i
+		}
+	}
+
+	// contextRank orders the base key first, then known contexts, then
+	// unknown ones.
+	contextRank := func(context string) int {
+		if context == "" {
+			return -1
+		}
+
+		first, _, _ := strings.Cut(context, ".")
+		if r, found := rank[first]; found {
+			return r
+		}
+
+		return len(contextOrder)
+	}
+
+	sort.SliceStable(variants, func(i, j int) bool {
+		_, ci := splitContext(variants[i].Key)
+		_, cj := splitContext(variants[j].Key)
+
+		ri, rj := contextRank(ci), contextRank(cj)
+		if ri != rj {
+			return ri < rj
+		}
+
+		return ci < cj
+	})
+}
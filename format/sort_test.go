@@ -0,0 +1,210 @@
+package format
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func settingsWithKeys(keys ...string) []*Setting {
+	settings := make([]*Setting, 0, len(keys))
+	for _, key := range keys {
+		settings = append(settings, &Setting{
+			Key:      key,
+			Variants: []Variant{{Key: key, Value: "1"}},
+		})
+	}
+
+	return settings
+}
+
+func settingKeys(settings []*Setting) []string {
+	keys := make([]string, 0, len(settings))
+	for _, setting := range settings {
+		keys = append(keys, setting.Key)
+	}
+
+	return keys
+}
+
+func TestSortStrategies(t *testing.T) {
+	tests := []struct {
+		strategy SortStrategy
+		want     []string
+	}{
+		{
+			strategy: SortUpperFirst,
+			want:     []string{"Alpha", "Gamma", "beta", "delta"},
+		},
+		{
+			strategy: SortAlpha,
+			want:     []string{"Alpha", "beta", "delta", "Gamma"},
+		},
+		{
+			strategy: SortLength,
+			want:     []string{"beta", "Alpha", "Gamma", "delta"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy.String(), func(t *testing.T) {
+			settings := settingsWithKeys("delta", "Gamma", "beta", "Alpha")
+
+			SortWithOptions(settings, SortOptions{Strategy: tt.strategy})
+
+			assert.Equal(t, tt.want, settingKeys(settings))
+		})
+	}
+}
+
+func TestSortAlphaKeepsCasesTogether(t *testing.T) {
+	settings := settingsWithKeys("server", "client", "Server")
+
+	SortWithOptions(settings, SortOptions{Strategy: SortAlpha})
+
+	assert.Equal(t, []string{"client", "Server", "server"}, settingKeys(settings))
+}
+
+func TestSortStrategySet(t *testing.T) {
+	var s SortStrategy
+
+	assert.NoError(t, s.Set("alpha"))
+	assert.Equal(t, SortAlpha, s)
+
+	assert.NoError(t, s.Set("upperfirst"))
+	assert.Equal(t, SortUpperFirst, s)
+
+	assert.NoError(t, s.Set("length"))
+	assert.Equal(t, SortLength, s)
+
+	assert.EqualError(t, s.Set("random"), `unknown sort strategy "random"`)
+}
+
+func variantKeys(variants []Variant) []string {
+	keys := make([]string, 0, len(variants))
+	for _, variant := range variants {
+		keys = append(keys, variant.Key)
+	}
+
+	return keys
+}
+
+func TestSortVariantsByContext(t *testing.T) {
+	settings := []*Setting{{
+		Key: "x",
+		Variants: []Variant{
+			{Key: "x.prod", Value: "3"},
+			{Key: "x.zone", Value: "5"},
+			{Key: "x.dev", Value: "2"},
+			{Key: "x.alpha", Value: "6"},
+			{Key: "x.prod.eu", Value: "4"},
+			{Key: "x", Value: "1"},
+		},
+	}}
+
+	SortWithOptions(settings, SortOptions{SortVariants: true, ContextOrder: DefaultContextOrder})
+
+	assert.Equal(t, []string{"x", "x.dev", "x.prod", "x.prod.eu", "x.alpha", "x.zone"}, variantKeys(settings[0].Variants))
+}
+
+func TestSortVariantsBaseDevProd(t *testing.T) {
+	settings := []*Setting{{
+		Key: "x",
+		Variants: []Variant{
+			{Key: "x.prod", Value: "3"},
+			{Key: "x.dev", Value: "2"},
+			{Key: "x", Value: "1"},
+		},
+	}}
+
+	SortWithOptions(settings, SortOptions{SortVariants: true, ContextOrder: []string{"dev", "prod"}})
+
+	assert.Equal(t, []string{"x", "x.dev", "x.prod"}, variantKeys(settings[0].Variants))
+
+	// Without SortVariants the input order is kept.
+	settings[0].Variants[0], settings[0].Variants[2] = settings[0].Variants[2], settings[0].Variants[0]
+
+	Sort(settings)
+
+	assert.Equal(t, []string{"x.prod", "x.dev", "x"}, variantKeys(settings[0].Variants))
+}
+
+func TestOrderVariants(t *testing.T) {
+	settings := []*Setting{
+		{Key: "b", Variants: []Variant{{Key: "b.dev", Value: "2", Commented: true}, {Key: "b", Value: "1"}}},
+		{Key: "a", Variants: []Variant{{Key: "a.prod", Value: "2"}, {Key: "a", Value: "1"}}},
+	}
+
+	OrderVariants(settings, SortOptions{SortVariants: true, LiveFirst: true})
+
+	assert.Equal(t, "b", settings[0].Key)
+	assert.Equal(t, []string{"b", "b.dev"}, variantKeys(settings[0].Variants))
+	assert.Equal(t, []string{"a", "a.prod"}, variantKeys(settings[1].Variants))
+}
+
+func TestSortLiveFirst(t *testing.T) {
+	settings := []*Setting{{
+		Key: "x",
+		Variants: []Variant{
+			{Key: "x.dev", Value: "a", Commented: true},
+			{Key: "x.prod", Value: "b"},
+			{Key: "x.test", Value: "c", Commented: true},
+			{Key: "x", Value: "d"},
+		},
+	}}
+
+	SortWithOptions(settings, SortOptions{LiveFirst: true})
+
+	assert.Equal(t, []string{"x.prod", "x", "x.dev", "x.test"}, variantKeys(settings[0].Variants))
+	assert.False(t, settings[0].Variants[1].Commented)
+	assert.True(t, settings[0].Variants[2].Commented)
+
+	// Combined with SortVariants, each group is in context order.
+	SortWithOptions(settings, SortOptions{LiveFirst: true, SortVariants: true, ContextOrder: []string{"test", "dev"}})
+
+	assert.Equal(t, []string{"x", "x.prod", "x.test", "x.dev"}, variantKeys(settings[0].Variants))
+}
+
+func TestSortStable(t *testing.T) {
+	var settings []*Setting
+
+	for i := 0; i < 50; i++ {
+		key := "b"
+		if i%3 == 0 {
+			key = "a"
+		}
+
+		settings = append(settings, &Setting{
+			Key:      key,
+			Variants: []Variant{{Key: key, Value: fmt.Sprint(i)}},
+		})
+	}
+
+	SortWithOptions(settings, SortOptions{Strategy: SortAlpha})
+
+	last := map[string]int{"a": -1, "b": -1}
+
+	for i, setting := range settings {
+		n, err := strconv.Atoi(setting.Variants[0].Value)
+		require.NoError(t, err)
+
+		assert.Greater(t, n, last[setting.Key], "position %d", i)
+		last[setting.Key] = n
+
+		if i > 0 {
+			assert.LessOrEqual(t, settings[i-1].Key, setting.Key)
+		}
+	}
+}
+
+func TestSortLength(t *testing.T) {
+	settings := settingsWithKeys("timeout", "db", "a", "port", "host", "Über")
+
+	SortWithOptions(settings, SortOptions{Strategy: SortLength})
+
+	// Ties are broken in byte order; length counts characters.
+	assert.Equal(t, []string{"a", "db", "host", "port", "Über", "timeout"}, settingKeys(settings))
+}
@@ -0,0 +1,37 @@
+package format
+
+import "strings"
+
+// Stamp is the comment that FormatOptions.Stamp writes as the first line of
+// the output. Its number is the version of the canonical form, which only
+// changes when the formatting rules do, so that stamped files stay the same
+// from one build of the formatter to the next.
+const Stamp = "formatted by gocore-format v1"
+
+// stampPrefix matches a stamp of any version.
+const stampPrefix = "formatted by gocore-format v"
+
+// StripStamp removes a stamp written by a previous run from the start of the
+// first comment block of settings, so that stamping again does not repeat
+// it. A stamp that was the only comment of a setting without variants takes
+// the setting with it.
+func StripStamp(settings []*Setting) []*Setting {
+	if len(settings) == 0 {
+		return settings
+	}
+
+	first := settings[0]
+
+	line, rest, _ := strings.Cut(first.Comments, "\n")
+	if !strings.HasPrefix(line, stampPrefix) {
+		return settings
+	}
+
+	first.Comments = strings.TrimLeft(rest, "\n")
+
+	if first.Comments == "" && len(first.Variants) == 0 {
+		return settings[1:]
+	}
+
+	return settings
+}
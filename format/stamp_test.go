@@ -0,0 +1,49 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStamp(t *testing.T) {
+	opts := FormatOptions{Stamp: true}
+
+	input := "# The port\nport = 80\nhost = a\n"
+
+	for i := 0; i < 3; i++ {
+		settings, err := Parse(strings.NewReader(input))
+		require.NoError(t, err)
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, FormatWithOptions(buf, StripStamp(settings), opts))
+
+		assert.Equal(t, "# "+Stamp+"\n\n# The port\nport = 80\n\nhost = a\n\n", buf.String(), "run %d", i+1)
+
+		input = buf.String()
+	}
+}
+
+func TestStripStamp(t *testing.T) {
+	for _, test := range []struct {
+		input    string
+		expected string
+	}{
+		// A stamp from another version is replaced too.
+		{"# formatted by gocore-format v0\n\na = 1\n", "a = 1\n\n"},
+		{"# formatted by gocore-format v1\n", ""},
+		// Only a stamp at the very start counts.
+		{"a = 1\n# formatted by gocore-format v1\nb = 2\n", "a = 1\n\n# formatted by gocore-format v1\nb = 2\n\n"},
+	} {
+		settings, err := Parse(strings.NewReader(test.input))
+		require.NoError(t, err)
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, Format(buf, StripStamp(settings)))
+
+		assert.Equal(t, test.expected, buf.String(), test.input)
+	}
+}
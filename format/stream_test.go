@@ -0,0 +1,104 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mediumFixture returns a few thousand lines of settings in the shapes the
+// parser handles: comment blocks, contexts, commented-out variants, inline
+// comments, continuation lines and sections.
+func mediumFixture() string {
+	var b strings.Builder
+
+	for i := 0; i < 500; i++ {
+		if i%100 == 0 {
+			fmt.Fprintf(&b, "# [part%d]\n\n", i/100)
+		}
+
+		if i%3 == 0 {
+			fmt.Fprintf(&b, "# Setting %d.\n#\n# Second paragraph.\n", i)
+		}
+
+		fmt.Fprintf(&b, "key%d=value%d\n", i, i)
+		fmt.Fprintf(&b, "key%d.dev = dev%d   # for dev\n", i, i)
+
+		if i%4 == 0 {
+			fmt.Fprintf(&b, "\n# key%d.prod = prod%d\n", i, i)
+		}
+
+		if i%5 == 0 {
+			fmt.Fprintf(&b, "# before local\nkey%d.dev.local = a|b | c\n", i)
+		}
+
+		if i%7 == 0 {
+			fmt.Fprintf(&b, "key%d.long = first \\\n  second\n", i)
+		}
+
+		b.WriteString("\n")
+	}
+
+	b.WriteString("# Trailing comment.\n")
+
+	return b.String()
+}
+
+func TestFormatStream(t *testing.T) {
+	input := mediumFixture()
+
+	tests := []struct {
+		name      string
+		parseOpts ParseOptions
+		opts      FormatOptions
+	}{
+		{name: "defaults"},
+		{name: "sections", parseOpts: ParseOptions{Sections: true}},
+		{name: "width", opts: FormatOptions{Width: 30, Stamp: true}},
+		{name: "crlf", opts: FormatOptions{CRLF: true, NoAlign: true, Separator: "="}},
+		{name: "fold case", parseOpts: ParseOptions{CaseInsensitiveGroup: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := ParseWithOptions(strings.NewReader(input), tt.parseOpts)
+			require.NoError(t, err)
+
+			var buffered bytes.Buffer
+			require.NoError(t, FormatWithOptions(&buffered, settings, tt.opts))
+
+			var streamed bytes.Buffer
+			require.NoError(t, FormatStream(&streamed, strings.NewReader(input), tt.parseOpts, tt.opts, nil))
+
+			assert.Equal(t, buffered.String(), streamed.String())
+		})
+	}
+}
+
+func TestFormatStreamEach(t *testing.T) {
+	var keys []string
+
+	var buf bytes.Buffer
+	err := FormatStream(&buf, strings.NewReader("a=1\n# a.dev=2\nb=3\n# end\n"), ParseOptions{}, FormatOptions{}, func(settings []*Setting) ([]*Setting, error) {
+		keys = append(keys, settings[0].Key)
+		return StripCommented(settings), nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a", "b", ""}, keys)
+	assert.Equal(t, "a = 1\n\nb = 3\n\n# end\n\n", buf.String())
+}
+
+func TestFormatStreamNotContiguous(t *testing.T) {
+	var buf bytes.Buffer
+	err := FormatStream(&buf, strings.NewReader("a=1\nb=2\na.dev=3\n"), ParseOptions{}, FormatOptions{}, nil)
+	require.ErrorIs(t, err, ErrNotContiguous)
+	assert.EqualError(t, err, `line 3: root key seen again after other keys: "a"`)
+
+	err = FormatStream(&buf, strings.NewReader("a=1\n"), ParseOptions{}, FormatOptions{AlignAll: true}, nil)
+	assert.EqualError(t, err, "cannot stream settings aligned to the longest key")
+}
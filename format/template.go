@@ -0,0 +1,110 @@
+package format
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ReadValues reads the key=value pairs of an env file for Template. Blank
+// lines and lines starting with '#' are skipped, a leading "export " is
+// ignored and a value wrapped in matching single or double quotes is
+// unquoted.
+func ReadValues(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+
+		if !found || key == "" {
+			return nil, fmt.Errorf("line %d: %q is not a key=value pair", lineNumber, line)
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		values[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// Template replaces each {{name}} placeholder in the values of settings
+// with values[name]. Spaces inside the braces are ignored. Keys and comments
+// are left alone. A placeholder with no value is an error unless
+// allowMissing is set, in which case it is left as written.
+func Template(settings []*Setting, values map[string]string, allowMissing bool) error {
+	for _, setting := range settings {
+		for i := range setting.Variants {
+			variant := &setting.Variants[i]
+
+			value, err := fill(variant.Value, values, allowMissing)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", variant.Line, err)
+			}
+
+			variant.Value = value
+		}
+	}
+
+	return nil
+}
+
+// fill replaces the placeholders in s.
+func fill(s string, values map[string]string, allowMissing bool) (string, error) {
+	var b strings.Builder
+
+	for {
+		start := strings.Index(s, "{{")
+		if start < 0 {
+			break
+		}
+
+		end := strings.Index(s[start:], "}}")
+		if end < 0 {
+			break
+		}
+
+		end += start + 2
+
+		name := strings.TrimSpace(s[start+2 : end-2])
+
+		value, found := values[name]
+		if !found {
+			if !allowMissing {
+				return "", fmt.Errorf("no value for placeholder %q", name)
+			}
+
+			value = s[start:end]
+		}
+
+		b.WriteString(s[:start])
+		b.WriteString(value)
+
+		s = s[end:]
+	}
+
+	b.WriteString(s)
+
+	return b.String(), nil
+}
@@ -0,0 +1,60 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadValues(t *testing.T) {
+	values, err := ReadValues(strings.NewReader(`# prod
+HOST = db.example.com
+
+export PORT=5432
+NAME="my app"
+EMPTY=
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"HOST":  "db.example.com",
+		"PORT":  "5432",
+		"NAME":  "my app",
+		"EMPTY": "",
+	}, values)
+
+	_, err = ReadValues(strings.NewReader("HOST\n"))
+	assert.EqualError(t, err, `line 1: "HOST" is not a key=value pair`)
+}
+
+func TestTemplate(t *testing.T) {
+	input := `# {{HOST}} is not templated here
+db.url = postgres://{{ HOST }}:{{PORT}}/app
+# db.url.dev = postgres://{{HOST}}/dev
+name = {{NAME}}
+`
+
+	values := map[string]string{"HOST": "db", "PORT": "5432"}
+
+	settings, err := Parse(strings.NewReader(input))
+	require.NoError(t, err)
+
+	assert.EqualError(t, Template(settings, values, false), `line 4: no value for placeholder "NAME"`)
+
+	settings, err = Parse(strings.NewReader(input))
+	require.NoError(t, err)
+
+	require.NoError(t, Template(settings, values, true))
+
+	assert.Equal(t, "{{HOST}} is not templated here", settings[0].Comments)
+	assert.Equal(t, "postgres://db:5432/app", settings[0].Variants[0].Value)
+	assert.Equal(t, "postgres://db/dev", settings[0].Variants[1].Value)
+	assert.Equal(t, "{{NAME}}", settings[1].Variants[0].Value)
+
+	values["NAME"] = "app"
+
+	require.NoError(t, Template(settings, values, false))
+	assert.Equal(t, "app", settings[1].Variants[0].Value)
+}
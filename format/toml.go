@@ -0,0 +1,199 @@
+package format
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// EncodeTOML writes settings to w as TOML. It is an export format only.
+//
+// A setting with no context variants becomes a top-level key. TOML does not
+// allow a key to be both a value and a table, so a setting with contexts
+// becomes a [key] table holding the base value as "value", followed by a
+// [key.contexts] table with one entry per context:
+//
+//	timeout = "30"
+//
+//	[db]
+//	value = "localhost"
+//
+//	[db.contexts]
+//	dev = "devhost"
+//	prod = ["a", "b"]
+//
+// A multi-value list such as "a | b" is written as an array of strings and
+// every other value as a string. Comments, inline comments and commented-out
+// variants are written as TOML comments. A key repeated at the top level or
+// within a table is written as a comment, since TOML does not allow
+// duplicate keys.
+func EncodeTOML(w io.Writer, settings []*Setting) error {
+	writer := bufio.NewWriter(w)
+
+	var tables []*Setting
+
+	for _, setting := range settings {
+		if len(setting.Variants) == 0 {
+			continue
+		}
+
+		if hasContexts(setting) {
+			tables = append(tables, setting)
+			continue
+		}
+
+		writeTOMLComments(writer, setting.Comments)
+
+		seen := false
+
+		for _, variant := range setting.Variants {
+			writeTOMLValue(writer, variant, tomlKey(variant.Key), seen)
+
+			seen = seen || !variant.Commented
+		}
+
+		writer.WriteString("\n")
+	}
+
+	for _, setting := range tables {
+		writeTOMLComments(writer, setting.Comments)
+
+		fmt.Fprintf(writer, "[%s]\n", tomlKey(setting.Key))
+
+		// The base value and the contexts are in different tables, so a
+		// context named value is not a duplicate of the base.
+		seenBase := false
+
+		for _, variant := range setting.Variants {
+			if _, context := splitContext(variant.Key); context == "" {
+				writeTOMLValue(writer, variant, "value", seenBase)
+
+				seenBase = seenBase || !variant.Commented
+			}
+		}
+
+		fmt.Fprintf(writer, "\n[%s.contexts]\n", tomlKey(setting.Key))
+
+		seen := make(map[string]bool)
+
+		for _, variant := range setting.Variants {
+			if _, context := splitContext(variant.Key); context != "" {
+				writeTOMLValue(writer, variant, tomlKey(context), seen[context])
+
+				seen[context] = seen[context] || !variant.Commented
+			}
+		}
+
+		writer.WriteString("\n")
+	}
+
+	for _, setting := range settings {
+		if len(setting.Variants) == 0 {
+			writeTOMLComments(writer, setting.Comments)
+		}
+	}
+
+	return writer.Flush()
+}
+
+// splitContext splits a variant key into its root key and context.
+func splitContext(key string) (root, context string) {
+	root, context, _ = strings.Cut(key, ".")
+
+	return root, context
+}
+
+func hasContexts(setting *Setting) bool {
+	for _, variant := range setting.Variants {
+		if _, context := splitContext(variant.Key); context != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeTOMLComments(w *bufio.Writer, comments string) {
+	if comments == "" {
+		return
+	}
+
+	for _, line := range strings.Split(comments, "\n") {
+		w.WriteString(strings.TrimRight("# "+line, " ") + "\n")
+	}
+}
+
+func writeTOMLValue(w *bufio.Writer, variant Variant, key string, duplicate bool) {
+	writeTOMLComments(w, variant.LeadingComment)
+
+	line := key + " = " + tomlValue(variant.Value)
+
+	if variant.Commented || duplicate {
+		line = "# " + line
+	}
+
+	if variant.Comment != "" {
+		line += " # " + variant.Comment
+	}
+
+	w.WriteString(line + "\n")
+}
+
+func tomlValue(value string) string {
+	parts := listValues(value)
+	if len(parts) == 1 {
+		return tomlString(parts[0])
+	}
+
+	for i, part := range parts {
+		parts[i] = tomlString(part)
+	}
+
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func tomlKey(key string) string {
+	if key == "" {
+		return `""`
+	}
+
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return tomlString(key)
+		}
+	}
+
+	return key
+}
+
+func tomlString(s string) string {
+	var b strings.Builder
+
+	b.WriteByte('"')
+
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+
+	b.WriteByte('"')
+
+	return b.String()
+}
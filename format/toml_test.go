@@ -0,0 +1,70 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeTOML(t *testing.T) {
+	reader := strings.NewReader(`
+		timeout = 30
+		# The database host
+		db = localhost # default
+		db.dev = devhost
+		# db.test = testhost
+		db.prod = a | b
+		url.host.prod = "x"
+	`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	Sort(settings)
+
+	buf := &bytes.Buffer{}
+	err = EncodeTOML(buf, settings)
+	require.NoError(t, err)
+
+	assert.Equal(t, `timeout = "30"
+
+# The database host
+[db]
+value = "localhost" # default
+
+[db.contexts]
+dev = "devhost"
+# test = "testhost"
+prod = ["a", "b"]
+
+[url]
+
+[url.contexts]
+"host.prod" = "\"x\""
+
+`, buf.String())
+}
+
+func TestEncodeTOMLDuplicates(t *testing.T) {
+	settings, err := Parse(strings.NewReader("a = 1\na = 2\nx = 1\nx.value = 2\nx.dev = 3\nx.dev = 4\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, EncodeTOML(buf, settings))
+
+	assert.Equal(t, `a = "1"
+# a = "2"
+
+[x]
+value = "1"
+
+[x.contexts]
+value = "2"
+dev = "3"
+# dev = "4"
+
+`, buf.String())
+}
@@ -0,0 +1,226 @@
+package format
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// StripCommented removes every commented-out variant and then every setting
+// left without variants, along with its comment block. Settings that had no
+// variants to begin with, such as a trailing comment block, are kept.
+func StripCommented(settings []*Setting) []*Setting {
+	kept := settings[:0]
+
+	for _, setting := range settings {
+		if len(setting.Variants) == 0 {
+			kept = append(kept, setting)
+			continue
+		}
+
+		live := setting.Variants[:0]
+		for _, variant := range setting.Variants {
+			if !variant.Commented {
+				live = append(live, variant)
+			}
+		}
+
+		setting.Variants = live
+
+		if len(live) > 0 {
+			kept = append(kept, setting)
+		}
+	}
+
+	return kept
+}
+
+// StripComments removes every comment block and leading and inline comment,
+// keeping both live and commented-out variants and include directives.
+// Settings that held only comments are dropped.
+func StripComments(settings []*Setting) []*Setting {
+	kept := settings[:0]
+
+	for _, setting := range settings {
+		if setting.Include != "" {
+			setting.Comments = ""
+			kept = append(kept, setting)
+
+			continue
+		}
+
+		if len(setting.Variants) == 0 {
+			continue
+		}
+
+		setting.Comments = ""
+		for i := range setting.Variants {
+			setting.Variants[i].Comment = ""
+			setting.Variants[i].LeadingComment = ""
+		}
+
+		kept = append(kept, setting)
+	}
+
+	return kept
+}
+
+// TrimEmpty removes every setting without variants, such as the comment
+// block at the end of a file, other than include directives. Format leaves out such settings without
+// comments on its own.
+func TrimEmpty(settings []*Setting) []*Setting {
+	kept := settings[:0]
+
+	for _, setting := range settings {
+		if len(setting.Variants) > 0 || setting.Include != "" {
+			kept = append(kept, setting)
+		}
+	}
+
+	return kept
+}
+
+// Context returns the settings as seen by context: for each root key, the
+// live variant chosen as by Settings.Resolve, written under the root key.
+// Commented-out variants and the variants of other contexts are dropped. A
+// setting with neither a variant for the context nor a base, such as one
+// with only key.prod when context is dev, is dropped along with its
+// comments. When a key is repeated the last variant wins.
+func Context(settings []*Setting, context string) []*Setting {
+	kept := settings[:0]
+
+	for _, setting := range settings {
+		if len(setting.Variants) == 0 {
+			kept = append(kept, setting)
+			continue
+		}
+
+		live := make(map[string]*Variant)
+
+		for i := range setting.Variants {
+			if variant := &setting.Variants[i]; !variant.Commented {
+				_, c := splitContext(variant.Key)
+				live[c] = variant
+			}
+		}
+
+		var match *Variant
+
+		for _, c := range contextChain(context) {
+			if match = live[c]; match != nil {
+				break
+			}
+		}
+
+		if match == nil {
+			continue
+		}
+
+		effective := *match
+		effective.Key, _ = splitContext(match.Key)
+		effective.BlankBefore = false
+
+		setting.Variants = []Variant{effective}
+
+		kept = append(kept, setting)
+	}
+
+	return kept
+}
+
+// CanonicalBools rewrites boolean values as true or false. Without a schema
+// only the words true, false, yes, no, on and off, in any case, are
+// rewritten, as 1 and 0 may well be numbers. With a schema only the values of
+// keys it types as bool are rewritten, and 1, 0, t and f are too.
+func CanonicalBools(settings []*Setting, schema *Schema) []*Setting {
+	for _, setting := range settings {
+		if schema != nil && schema.Keys[setting.Key].Type != "bool" {
+			continue
+		}
+
+		for i := range setting.Variants {
+			variant := &setting.Variants[i]
+
+			if b, ok := parseBool(variant.Value, schema != nil); ok {
+				variant.Value = strconv.FormatBool(b)
+			}
+		}
+	}
+
+	return settings
+}
+
+// parseBool parses a boolean word, or also 1, 0, t or f if short is set.
+func parseBool(s string, short bool) (value, ok bool) {
+	switch strings.ToLower(s) {
+	case "true", "yes", "on":
+		return true, true
+	case "false", "no", "off":
+		return false, true
+	case "1", "t":
+		return true, short
+	case "0", "f":
+		return false, short
+	}
+
+	return false, false
+}
+
+// NormalizeURLs rewrites values that are URLs, and the elements of pipe
+// lists that are, with the scheme and host in lower case, without the
+// default port of http or https and without trailing slashes on the path.
+// Lists are split as Format splits them, and keep their spacing. Anything
+// that is not an absolute URL with a host is left as written.
+func NormalizeURLs(settings []*Setting) []*Setting {
+	for _, setting := range settings {
+		for i := range setting.Variants {
+			variant := &setting.Variants[i]
+
+			// The elements keep their spacing, as Format only respaces
+			// lists when asked to.
+			parts := splitMultiValues(variant.Value)
+			for j, part := range parts {
+				trimmed := strings.TrimSpace(part)
+				if trimmed != "" {
+					parts[j] = strings.Replace(part, trimmed, normalizeURL(trimmed), 1)
+				}
+			}
+
+			variant.Value = strings.Join(parts, "|")
+		}
+	}
+
+	return settings
+}
+
+// defaultPorts are the ports left out of normalized URLs, by scheme.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// normalizeURL returns s normalized as by NormalizeURLs.
+func normalizeURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return s
+	}
+
+	// Only URLs that are written back as they were read, but for case, are
+	// normalized, so that nothing else about them is changed.
+	if !strings.EqualFold(u.String(), s) {
+		return s
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+
+	if port := u.Port(); port != "" && defaultPorts[u.Scheme] == port {
+		u.Host = strings.TrimSuffix(u.Host, ":"+port)
+	}
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+
+	return u.String()
+}
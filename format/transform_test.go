@@ -0,0 +1,202 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripCommented(t *testing.T) {
+	reader := strings.NewReader(`
+		# Section a
+		a = 1
+		# a.dev = 2
+		a.prod = 3 # live
+		# Only commented
+		# b = 1
+		# b.dev = 2
+		c = 1
+		# c.test = 2
+		# footer
+	`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	settings = StripCommented(settings)
+
+	Sort(settings)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+
+	assert.Equal(t, `# Section a
+a      = 1
+a.prod = 3 # live
+
+c = 1
+
+# footer
+
+`, buf.String())
+}
+
+func TestStripComments(t *testing.T) {
+	reader := strings.NewReader(`
+		# Section a
+		a = 1 # one
+		# a.dev = 2 # two
+		b = 1
+		# footer
+	`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	settings = StripComments(settings)
+
+	Sort(settings)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, FormatWithOptions(buf, settings, FormatOptions{AlignComments: true}))
+
+	assert.Equal(t, "a       = 1\n# a.dev = 2\n\nb = 1\n\n", buf.String())
+}
+
+func TestContext(t *testing.T) {
+	reader := strings.NewReader(`
+		# Database host
+		db = localhost
+		db.prod = db.example.com # primary
+		timeout = 30
+		timeout.dev = 300
+		# timeout.prod = 10
+		debug.dev = true
+	`)
+
+	settings, err := Parse(reader)
+	require.NoError(t, err)
+
+	settings = Context(settings, "prod")
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+
+	assert.Equal(t, `# Database host
+db = db.example.com # primary
+
+timeout = 30
+
+`, buf.String())
+}
+
+func TestCanonicalBools(t *testing.T) {
+	parse := func(input string) []*Setting {
+		settings, err := Parse(strings.NewReader(input))
+		require.NoError(t, err)
+
+		return settings
+	}
+
+	values := func(settings []*Setting) []string {
+		var v []string
+		for _, setting := range settings {
+			for _, variant := range setting.Variants {
+				v = append(v, variant.Value)
+			}
+		}
+
+		return v
+	}
+
+	input := "debug = yes\ndebug.prod = 0\nverbose = Off\nport = 0\nname = yesterday\n"
+
+	assert.Equal(t, []string{"true", "0", "false", "0", "yesterday"}, values(CanonicalBools(parse(input), nil)))
+
+	schema := &Schema{Keys: map[string]KeySchema{
+		"debug":   {Type: "bool"},
+		"verbose": {Type: "string"},
+		"port":    {Type: "int"},
+	}}
+
+	assert.Equal(t, []string{"true", "false", "Off", "0", "yesterday"}, values(CanonicalBools(parse(input), schema)))
+}
+
+func TestContextWithoutBase(t *testing.T) {
+	input := `# Only prod has a CDN
+cdn.prod = cdn.example.com
+db = localhost
+db.dev = dev-db
+log.dev.local = /tmp/log
+`
+
+	for _, test := range []struct {
+		context  string
+		expected string
+	}{
+		{"test", "db = localhost\n\n"},
+		{"dev", "db = dev-db\n\n"},
+		{"dev.local", "db = dev-db\n\nlog = /tmp/log\n\n"},
+		{"prod", "# Only prod has a CDN\ncdn = cdn.example.com\n\ndb = localhost\n\n"},
+	} {
+		settings, err := Parse(strings.NewReader(input))
+		require.NoError(t, err)
+
+		// Resolve agrees on which keys have a value.
+		_, ok := Settings(settings).Resolve("cdn", test.context)
+		assert.Equal(t, test.context == "prod", ok, test.context)
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, Format(buf, Context(settings, test.context)))
+
+		assert.Equal(t, test.expected, buf.String(), test.context)
+	}
+}
+
+func TestTrimEmpty(t *testing.T) {
+	settings := []*Setting{
+		{Key: "a", Variants: []Variant{{Key: "a", Value: "1"}}},
+		{Key: "gone"},
+		{Key: "b", Variants: []Variant{{Key: "b", Value: "2"}}},
+		{Comments: "trailing note"},
+	}
+
+	// Format leaves out the empty setting without comments by itself.
+	buf := &bytes.Buffer{}
+	require.NoError(t, Format(buf, settings))
+	assert.Equal(t, "a = 1\n\nb = 2\n\n# trailing note\n\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, Format(buf, TrimEmpty(settings)))
+	assert.Equal(t, "a = 1\n\nb = 2\n\n", buf.String())
+}
+
+func TestNormalizeURLs(t *testing.T) {
+	tests := map[string]string{
+		"HTTP://Example.com:80/":               "http://example.com",
+		"https://Example.com:443/api//":        "https://example.com/api",
+		"https://example.com:8443/":            "https://example.com:8443",
+		"http://example.com:443/":              "http://example.com:443",
+		"HTTPS://User@Host.com/Path/?Q=A#Frag": "https://User@host.com/Path?Q=A#Frag",
+		"http://[::1]:80/":                     "http://[::1]",
+		"postgres://DB:5432/app":               "postgres://db:5432/app",
+		"example.com/path/":                    "example.com/path/",
+		"/var/lib/":                            "/var/lib/",
+		"mailto:Admin@Example.com":             "mailto:Admin@Example.com",
+		"http://example.com/a b/":              "http://example.com/a b/",
+		"HTTP://A.com/ | Not A URL | b":        "http://a.com | Not A URL | b",
+		"a|HTTPS://B.com:443/":                 "a|https://b.com",
+		"HTTP://A.com/  |  HTTP://B.com/":      "http://a.com  |  http://b.com",
+		`"HTTP://A.com/|x"`:                    `"HTTP://A.com/|x"`,
+		`HTTP://A.com/a\|b/`:                   `HTTP://A.com/a\|b/`,
+		"HTTP://A.com/(a|b)/":                  "HTTP://A.com/(a|b)/",
+	}
+
+	for input, want := range tests {
+		settings := NormalizeURLs([]*Setting{{Key: "url", Variants: []Variant{{Key: "url", Value: input}}}})
+		assert.Equal(t, want, settings[0].Variants[0].Value, input)
+	}
+}